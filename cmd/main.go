@@ -1,39 +1,96 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 	"twitchannouncer/internal/bot"
 	"twitchannouncer/internal/config"
 	"twitchannouncer/internal/database"
+	"twitchannouncer/internal/eventsub"
+	"twitchannouncer/internal/twitch"
 	"twitchannouncer/internal/yookassa"
 )
 
 func main() {
-	cfg := config.LoadConfig("config.yaml")
-	err := config.RefreshTwitchToken(&cfg, "config.yaml")
+	cfg, args, err := config.Load(os.Args[1:])
 	if err != nil {
-		log.Fatalf("Ошибка обновления Twitch токена: %v", err)
+		log.Fatalf("Ошибка загрузки конфигурации:\n%v", err)
 	}
 
-	go config.RefreshTokenPeriodically(&cfg)
+	if len(args) > 0 && args[0] == "config" {
+		if err := runConfig(cfg, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		cfg.DatabaseUser,
-		cfg.DatabasePassword,
-		cfg.DatabaseHost,
-		cfg.DatabasePort,
-		cfg.DatabaseName,
-	)
+	// Миграциям нужна только база, остальные настройки не проверяем
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := cfg.ValidateDatabase(); err != nil {
+			log.Fatalf("Ошибка в конфигурации:\n%v", err)
+		}
+		db, err := database.InitDatabase(cfg.DatabaseURL())
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = runMigrate(db, args[1:])
+		db.Pool.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	db, err := database.InitDatabase(connStr)
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Ошибка в конфигурации:\n%v", err)
+	}
+
+	db, err := database.InitDatabase(cfg.DatabaseURL())
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	applied, err := db.MigrateUp(context.Background())
+	if err != nil {
+		log.Fatalf("Ошибка применения миграций: %v", err)
+	}
+	log.Printf("Применено миграций: %d", applied)
+
+	// Корневой контекст отменяется по SIGINT/SIGTERM, например при docker compose down
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var tokenStore twitch.TokenStore
+	switch cfg.TwitchTokenStore {
+	case "memory":
+		tokenStore = twitch.NewMemoryTokenStore()
+	case "file":
+		tokenStore = twitch.NewFileTokenStore(cfg.TwitchTokenFile)
+	default:
+		tokenStore = twitch.NewPostgresTokenStore(db, cfg.TwitchClientID)
+	}
+
+	// Клиент сам обновляет токен перед истечением и после ответа 401
+	twitchClient := twitch.NewClient(twitch.Options{
+		ClientID:     cfg.TwitchClientID,
+		ClientSecret: cfg.TwitchClientSecret,
+		HelixURL:     cfg.TwitchHelixURL,
+		AuthURL:      cfg.TwitchAuthURL,
+		Store:        tokenStore,
+	})
+	if _, err := twitchClient.Token(ctx); err != nil {
+		log.Fatalf("Ошибка обновления Twitch токена: %v", err)
+	}
+	go twitch.KeepTokenFresh(ctx, twitchClient)
+
 	botAPI, err := tgbotapi.NewBotAPI(cfg.TelegramToken)
 	if err != nil {
 		log.Panic(err)
@@ -41,15 +98,172 @@ func main() {
 
 	log.Printf("Authorized on account %s", botAPI.Self.UserName)
 
-	go bot.StartBot(cfg, botAPI, db)
-	go bot.StartProExpiryChecker(botAPI, db, 60*time.Minute)
+	mux := http.NewServeMux()
 
-	http.HandleFunc("/yookassa/webhook", yookassa.HandleWebhook(db, botAPI))
+	// Оповещения, уведомления об оплате и окончании Pro идут через общую
+	// очередь, чтобы не превысить лимиты Telegram
+	queue := bot.NewSendQueue(botAPI)
+	queue.Start()
 
-	log.Println("Starting HTTP server on :8080")
-	err = http.ListenAndServe(":8080", nil)
+	monitor := bot.NewMonitor(queue, db, cfg, twitchClient)
+	monitor.Start(ctx, cfg.PollInterval)
 
-	if err != nil {
-		log.Fatalf("HTTP server failed: %v", err)
+	if cfg.EventSubEnabled() {
+		manager := eventsub.NewManager(cfg, db, twitchClient)
+		db.OnSubscriptionsChanged = manager.Trigger
+		manager.Start(ctx, 10*time.Minute)
+
+		mux.HandleFunc("/twitch/eventsub", eventsub.HandleWebhook(cfg.EventSubSecret, monitor))
+		log.Printf("EventSub включён, опрос Twitch: %v", cfg.PollingActive())
+	}
+
+	var conversations bot.ConversationStore
+	switch cfg.ConversationStore {
+	case "memory":
+		conversations = bot.NewMemoryConversationStore()
+	default:
+		conversations = bot.NewPostgresConversationStore(db)
+	}
+
+	payments := yookassa.NewClient(yookassa.Options{
+		ShopID:    cfg.YooKassaShopID,
+		SecretKey: cfg.YooKassaSecretKey,
+		Plans:     yookassa.Plans(cfg),
+		Currency:  cfg.ProCurrency,
+		ReturnURL: cfg.PaymentReturnURL,
+	})
+
+	botDone := make(chan struct{})
+	go func() {
+		defer close(botDone)
+		bot.StartBot(ctx, botAPI, queue, db, twitchClient, payments, conversations)
+	}()
+	bot.StartProExpiryChecker(ctx, queue, db, cfg.ProExpiryCheckInterval)
+
+	mux.HandleFunc("/yookassa/webhook", yookassa.HandleWebhook(cfg, db, queue, payments))
+
+	server := &http.Server{
+		Addr:    cfg.ListenAddr,
+		Handler: mux,
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Starting HTTP server on %s", cfg.ListenAddr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			serverErr <- err
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		log.Println("Получен сигнал завершения, останавливаемся...")
+	case err := <-serverErr:
+		log.Printf("HTTP server failed: %v", err)
+		stop()
+	}
+
+	shutdown(server, botDone, monitor, queue, db)
+}
+
+// shutdown останавливает компоненты в порядке зависимостей: сначала перестаём
+// принимать вебхуки, затем дожидаемся обработки полученных обновлений и
+// начатых оповещений, и только после этого останавливаем очередь отправки и
+// закрываем базу
+func shutdown(server *http.Server, botDone <-chan struct{}, monitor *bot.Monitor, queue *bot.SendQueue, db *database.DB) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Ошибка остановки HTTP сервера: %v", err)
+	}
+
+	select {
+	case <-botDone:
+	case <-ctx.Done():
+		log.Println("Не дождались обработки обновлений Telegram")
+	}
+
+	monitorDone := make(chan struct{})
+	go func() {
+		monitor.Wait()
+		close(monitorDone)
+	}()
+	select {
+	case <-monitorDone:
+	case <-ctx.Done():
+		log.Println("Не дождались завершения мониторинга")
+	}
+
+	queue.Stop()
+	db.Pool.Close()
+	log.Println("Бот остановлен")
+}
+
+// runConfig обрабатывает подкоманду config print [--redacted]
+func runConfig(cfg config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return fmt.Errorf("использование: config print [--redacted]")
+	}
+
+	redacted := false
+	for _, arg := range args[1:] {
+		if arg != "--redacted" && arg != "-redacted" {
+			return fmt.Errorf("неизвестный аргумент config print: %s", arg)
+		}
+		redacted = true
+	}
+	if redacted {
+		cfg = cfg.Redacted()
+	}
+
+	if err := config.Print(os.Stdout, cfg); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибки в конфигурации:\n%v\n", err)
+	}
+	return nil
+}
+
+// runMigrate обрабатывает подкоманду migrate up|down [N]|status
+func runMigrate(db *database.DB, args []string) error {
+	ctx := context.Background()
+	if len(args) == 0 {
+		return fmt.Errorf("использование: migrate up|down [N]|status")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("Применено миграций: %d", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("неверное количество миграций: %s", args[1])
+			}
+			steps = n
+		}
+		return db.MigrateDown(ctx, steps)
+	case "status":
+		statuses, err := db.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "не применена"
+			if status.AppliedAt != nil {
+				state = "применена " + status.AppliedAt.Format("02.01.2006 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, state)
+		}
+	default:
+		return fmt.Errorf("неизвестная команда migrate %s", args[0])
 	}
+	return nil
 }
@@ -9,39 +9,97 @@ import (
 	"strings"
 	"time"
 
-	"twitchannouncer/internal/config"
 	"twitchannouncer/internal/database"
+	"twitchannouncer/internal/twitch"
 	"twitchannouncer/internal/yookassa"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
-var userState = make(map[int64]string)
-var userData database.UserData
-var subscriptionData database.SubscriptionData
+// Handler обрабатывает обновления Telegram
+type Handler struct {
+	bot *tgbotapi.BotAPI
+	// queue отправляет уведомления владельцам подписок с учётом лимитов
+	// Telegram; ответы на команды идут напрямую через bot
+	queue         *SendQueue
+	db            *database.DB
+	twitch        twitch.Client
+	payments      *yookassa.Client
+	conversations ConversationStore
+}
+
+func NewHandler(bot *tgbotapi.BotAPI, queue *SendQueue, db *database.DB, twitchClient twitch.Client, payments *yookassa.Client, conversations ConversationStore) *Handler {
+	return &Handler{
+		bot:           bot,
+		queue:         queue,
+		db:            db,
+		twitch:        twitchClient,
+		payments:      payments,
+		conversations: conversations,
+	}
+}
+
+// twitchLoginRegex — допустимый логин Twitch
+var twitchLoginRegex = regexp.MustCompile(`^[a-z0-9_]{1,25}$`)
+
+var offlineModes = []string{
+	database.OfflineModeDelete,
+	database.OfflineModeSummary,
+	database.OfflineModeKeep,
+	database.OfflineModeSeparate,
+}
+
+var offlineModeNames = map[string]string{
+	database.OfflineModeDelete:   "удалить оповещение",
+	database.OfflineModeSummary:  "заменить итогами",
+	database.OfflineModeKeep:     "оставить как есть",
+	database.OfflineModeSeparate: "итоги отдельным сообщением",
+}
 
-func StartBot(cfg config.Config, bot *tgbotapi.BotAPI, db *database.DB) {
-	ctx := context.Background()
-	monitor := NewMonitor(bot, db, cfg)
-	go monitor.Start(ctx, 10*time.Second)
+// StartBot обрабатывает обновления до отмены ctx. После отмены получение
+// обновлений останавливается, а уже полученные обрабатываются до выхода.
+func StartBot(ctx context.Context, bot *tgbotapi.BotAPI, queue *SendQueue, db *database.DB, twitchClient twitch.Client, payments *yookassa.Client, conversations ConversationStore) {
+	h := NewHandler(bot, queue, db, twitchClient, payments, conversations)
 
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 	updates := bot.GetUpdatesChan(u)
 
-	for update := range updates {
-		if update.CallbackQuery != nil {
-			handleCallbackQuery(bot, db, update.CallbackQuery)
-			continue
-		}
-		if update.Message == nil {
-			continue
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			h.dispatch(update)
+		case <-ctx.Done():
+			// Канал закрывается, когда завершится текущий long polling;
+			// обновления, полученные до этого, тоже обрабатываем
+			bot.StopReceivingUpdates()
+			for update := range updates {
+				h.dispatch(update)
+			}
+			return
 		}
-		handleUpdate(bot, db, update)
 	}
 }
 
-func handleCallbackQuery(bot *tgbotapi.BotAPI, db *database.DB, callback *tgbotapi.CallbackQuery) {
+func (h *Handler) dispatch(update tgbotapi.Update) {
+	if update.CallbackQuery != nil {
+		h.handleCallbackQuery(update.CallbackQuery)
+		return
+	}
+	if update.MyChatMember != nil {
+		h.handleMyChatMember(update.MyChatMember)
+		return
+	}
+	if update.Message == nil {
+		return
+	}
+	h.handleUpdate(update)
+}
+
+func (h *Handler) handleCallbackQuery(callback *tgbotapi.CallbackQuery) {
 	chatID := callback.Message.Chat.ID
 	messageID := callback.Message.MessageID
 	userID := callback.From.ID
@@ -51,13 +109,13 @@ func handleCallbackQuery(bot *tgbotapi.BotAPI, db *database.DB, callback *tgbota
 	case strings.HasPrefix(data, "list_page_"):
 		pageStr := strings.TrimPrefix(data, "list_page_")
 		page, _ := strconv.Atoi(pageStr)
-		subs, _ := db.GetUserSubscriptions(userID)
+		subs, _ := h.db.GetUserSubscriptions(userID)
 
 		msgText, keyboard := buildSubscriptionPage(subs, page)
 		edit := tgbotapi.NewEditMessageText(chatID, messageID, msgText)
 		edit.ParseMode = "Markdown"
 		edit.ReplyMarkup = &keyboard
-		bot.Send(edit)
+		h.bot.Send(edit)
 
 	case strings.HasPrefix(data, "delete_sub_"):
 		idStr := strings.TrimPrefix(data, "delete_sub_")
@@ -67,16 +125,9 @@ func handleCallbackQuery(bot *tgbotapi.BotAPI, db *database.DB, callback *tgbota
 			return
 		}
 
-		subscriptions, _ := db.GetUserSubscriptions(userID)
-		var sub *database.SubscriptionData
-		for _, s := range subscriptions {
-			if s.ID == id {
-				sub = &s
-				break
-			}
-		}
+		sub := h.findUserSubscription(userID, id)
 		if sub == nil {
-			bot.Send(tgbotapi.NewMessage(chatID, "❗ Подписка не найдена."))
+			h.bot.Send(tgbotapi.NewMessage(chatID, "❗ Подписка не найдена."))
 			return
 		}
 
@@ -90,31 +141,189 @@ func handleCallbackQuery(bot *tgbotapi.BotAPI, db *database.DB, callback *tgbota
 			),
 		)
 		edit.ParseMode = "Markdown"
-		bot.Send(edit)
+		h.bot.Send(edit)
 
 	case strings.HasPrefix(data, "confirm_sub_"):
 		idStr := strings.TrimPrefix(data, "confirm_sub_")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			log.Printf("Неверный ID подписки: %v", err)
-			bot.Send(tgbotapi.NewCallback(callback.ID, "Ошибка при удалении подписки"))
+			h.bot.Send(tgbotapi.NewCallback(callback.ID, "Ошибка при удалении подписки"))
 			return
 		}
 
-		err = db.DeleteSubscriptionByID(id)
+		err = h.db.DeleteSubscriptionByID(id)
 		if err != nil {
 			log.Printf("Ошибка удаления подписки: %v", err)
-			bot.Send(tgbotapi.NewCallback(callback.ID, "Ошибка при удалении подписки"))
+			h.bot.Send(tgbotapi.NewCallback(callback.ID, "Ошибка при удалении подписки"))
 			return
 		}
 
 		text := "✅ Подписка успешно удалена."
 		edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
 		edit.ParseMode = "Markdown"
-		bot.Send(edit)
+		h.bot.Send(edit)
+
+	case strings.HasPrefix(data, "sub_menu_"):
+		sub := h.callbackSubscription(callback, "sub_menu_")
+		if sub == nil {
+			return
+		}
+		text, keyboard := buildSubscriptionMenu(*sub)
+		h.bot.Send(tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, text, keyboard))
+
+	case strings.HasPrefix(data, "sub_live_"):
+		sub := h.callbackSubscription(callback, "sub_live_")
+		if sub == nil {
+			return
+		}
+		sub.LiveUpdates = !sub.LiveUpdates
+		if err := h.db.SetSubscriptionLiveUpdates(sub.ID, userID, sub.LiveUpdates); err != nil {
+			log.Printf("Ошибка изменения настроек подписки: %v", err)
+			h.bot.Send(tgbotapi.NewCallback(callback.ID, "Ошибка при сохранении настроек"))
+			return
+		}
+		text, keyboard := buildSubscriptionMenu(*sub)
+		h.bot.Send(tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, text, keyboard))
+
+	case strings.HasPrefix(data, "sub_photo_"):
+		sub := h.callbackSubscription(callback, "sub_photo_")
+		if sub == nil {
+			return
+		}
+		sub.PhotoMode = !sub.PhotoMode
+		if err := h.db.SetSubscriptionPhotoMode(sub.ID, userID, sub.PhotoMode); err != nil {
+			log.Printf("Ошибка изменения настроек подписки: %v", err)
+			h.bot.Send(tgbotapi.NewCallback(callback.ID, "Ошибка при сохранении настроек"))
+			return
+		}
+		text, keyboard := buildSubscriptionMenu(*sub)
+		h.bot.Send(tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, text, keyboard))
+
+	case strings.HasPrefix(data, "sub_offline_"):
+		sub := h.callbackSubscription(callback, "sub_offline_")
+		if sub == nil {
+			return
+		}
+		rows := [][]tgbotapi.InlineKeyboardButton{}
+		for _, mode := range offlineModes {
+			label := offlineModeNames[mode]
+			if mode == sub.OfflineMode {
+				label = "✅ " + label
+			}
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("sub_offmode_%s_%d", mode, sub.ID)),
+			))
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔙 Назад", fmt.Sprintf("sub_menu_%d", sub.ID)),
+		))
+		text := "Что сделать с оповещением, когда стрим закончится?"
+		h.bot.Send(tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, text, tgbotapi.NewInlineKeyboardMarkup(rows...)))
+
+	case strings.HasPrefix(data, "sub_offmode_"):
+		mode, idStr, _ := strings.Cut(strings.TrimPrefix(data, "sub_offmode_"), "_")
+		if _, ok := offlineModeNames[mode]; !ok {
+			log.Printf("Неверный режим окончания стрима: %s", mode)
+			return
+		}
+		sub := h.callbackSubscription(callback, "sub_offmode_"+mode+"_")
+		if sub == nil {
+			return
+		}
+		if err := h.db.SetSubscriptionOfflineMode(sub.ID, userID, mode); err != nil {
+			log.Printf("Ошибка изменения настроек подписки %s: %v", idStr, err)
+			h.bot.Send(tgbotapi.NewCallback(callback.ID, "Ошибка при сохранении настроек"))
+			return
+		}
+		sub.OfflineMode = mode
+		text, keyboard := buildSubscriptionMenu(*sub)
+		h.bot.Send(tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, text, keyboard))
+
+	case strings.HasPrefix(data, "sub_resume_"):
+		sub := h.callbackSubscription(callback, "sub_resume_")
+		if sub == nil {
+			return
+		}
+		h.handleResumeCallback(callback, *sub)
+
+	case strings.HasPrefix(data, "template_"):
+		h.handleTemplateCallback(callback)
+
+	case data == "streamer_confirm", data == "streamer_retry":
+		h.handleStreamerConfirmation(callback)
+
+	case strings.HasPrefix(data, "pro_plan_"):
+		h.handleProPlanCallback(callback)
+
+	case strings.HasPrefix(data, "target_"):
+		h.handleChatTargetCallback(callback)
+	}
+
+	h.bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+}
+
+// callbackSubscription находит подписку пользователя по ID из callback-данных вида prefix<ID>
+func (h *Handler) callbackSubscription(callback *tgbotapi.CallbackQuery, prefix string) *database.SubscriptionData {
+	id, err := strconv.Atoi(strings.TrimPrefix(callback.Data, prefix))
+	if err != nil {
+		log.Printf("Неверный ID подписки: %v", err)
+		return nil
 	}
+	sub := h.findUserSubscription(callback.From.ID, id)
+	if sub == nil {
+		h.bot.Send(tgbotapi.NewMessage(callback.Message.Chat.ID, "❗ Подписка не найдена."))
+	}
+	return sub
+}
 
-	bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+func (h *Handler) findUserSubscription(userID int64, id int) *database.SubscriptionData {
+	subscriptions, _ := h.db.GetUserSubscriptions(userID)
+	for _, s := range subscriptions {
+		if s.ID == id {
+			return &s
+		}
+	}
+	return nil
+}
+
+func buildSubscriptionMenu(sub database.SubscriptionData) (string, tgbotapi.InlineKeyboardMarkup) {
+	liveUpdates := "выкл"
+	if sub.LiveUpdates {
+		liveUpdates = "вкл"
+	}
+	photoMode := "выкл"
+	if sub.PhotoMode {
+		photoMode = "вкл"
+	}
+
+	text := fmt.Sprintf("Подписка %s → %s", sub.TwitchUsername, sub.ChannelName)
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if sub.Paused {
+		text += fmt.Sprintf("\n\n⏸ На паузе: %s", sub.PauseReason)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Включить снова", fmt.Sprintf("sub_resume_%d", sub.ID)),
+		))
+	}
+	rows = append(rows,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Обновлять во время стрима: "+liveUpdates, fmt.Sprintf("sub_live_%d", sub.ID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🖼 Превью стрима: "+photoMode, fmt.Sprintf("sub_photo_%d", sub.ID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🏁 После стрима: "+offlineModeNames[sub.OfflineMode], fmt.Sprintf("sub_offline_%d", sub.ID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📝 Шаблон", fmt.Sprintf("template_sub_%d", sub.ID)),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Удалить", fmt.Sprintf("delete_sub_%d", sub.ID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔙 Назад", "list_page_0"),
+		),
+	)
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
 func buildSubscriptionPage(subs []database.SubscriptionData, page int) (string, tgbotapi.InlineKeyboardMarkup) {
@@ -132,7 +341,10 @@ func buildSubscriptionPage(subs []database.SubscriptionData, page int) (string,
 
 	for _, sub := range paginated {
 		text := fmt.Sprintf("%s → %s", sub.TwitchUsername, sub.ChannelName)
-		callbackData := fmt.Sprintf("delete_sub_%d", sub.ID) // только ID
+		if sub.Paused {
+			text = "⏸ " + text
+		}
+		callbackData := fmt.Sprintf("sub_menu_%d", sub.ID) // только ID
 
 		button := tgbotapi.NewInlineKeyboardButtonData(text, callbackData)
 		rows = append(rows, tgbotapi.NewInlineKeyboardRow(button))
@@ -153,184 +365,586 @@ func buildSubscriptionPage(subs []database.SubscriptionData, page int) (string,
 	return msg.String(), keyboard
 }
 
-func handleUpdate(bot *tgbotapi.BotAPI, db *database.DB, update tgbotapi.Update) {
+func conversationKey(message *tgbotapi.Message) ConversationKey {
+	key := ConversationKey{ChatID: message.Chat.ID}
+	if message.From != nil {
+		key.UserID = message.From.ID
+	}
+	return key
+}
+
+func (h *Handler) setConversation(key ConversationKey, conv Conversation) {
+	if err := h.conversations.Set(context.Background(), key, conv); err != nil {
+		log.Printf("Ошибка сохранения диалога %d/%d: %v", key.ChatID, key.UserID, err)
+	}
+}
+
+func (h *Handler) endConversation(key ConversationKey) {
+	if err := h.conversations.Delete(context.Background(), key); err != nil {
+		log.Printf("Ошибка удаления диалога %d/%d: %v", key.ChatID, key.UserID, err)
+	}
+}
+
+func (h *Handler) handleUpdate(update tgbotapi.Update) {
 	chatID := update.Message.Chat.ID
 
 	if update.Message.IsCommand() {
-		handleCommand(bot, db, update)
+		h.handleCommand(update)
+		return
+	}
+
+	key := conversationKey(update.Message)
+	conv, err := h.conversations.Get(context.Background(), key)
+	if err != nil {
+		log.Printf("Ошибка получения диалога %d/%d: %v", key.ChatID, key.UserID, err)
 		return
 	}
 
-	switch userState[chatID] {
-	case "awaiting_username":
-		handleAwaitingUsername(bot, db, update)
-	case "awaiting_channel":
-		handleAwaitingChannel(bot, db, update)
-	case "awaiting_email":
-		handleAwaitingEmail(bot, db, update)
+	if conv.Expired(time.Now()) {
+		h.endConversation(key)
+		h.bot.Send(tgbotapi.NewMessage(chatID, "⌛ Время ожидания ответа истекло. Начните заново."))
+		return
+	}
+
+	switch conv.State {
+	case StateAwaitingUsername, StateConfirmingUsername:
+		// Новый логин вместо нажатия кнопки подтверждения тоже принимаем
+		h.handleAwaitingUsername(update, conv)
+	case StateAwaitingChannel:
+		h.handleAwaitingChannel(update, conv)
+	case StateAwaitingEmail:
+		h.handleAwaitingEmail(update, conv)
+	case StateAwaitingTemplate:
+		h.handleAwaitingTemplate(update, conv)
+	case StateAwaitingDeleteUsername:
+		h.handleAwaitingDeleteUsername(update)
 	}
 }
 
-func handleCommand(bot *tgbotapi.BotAPI, db *database.DB, update tgbotapi.Update) {
+func (h *Handler) handleCommand(update tgbotapi.Update) {
 	chatID := update.Message.Chat.ID
+	key := conversationKey(update.Message)
 	switch update.Message.Command() {
 	case "start":
-		bot.Send(tgbotapi.NewMessage(chatID, "Вас приветствует бот для автоматической отправки уведомлений о стримах.\n/help для просмотра доступных комманд!"))
+		h.bot.Send(tgbotapi.NewMessage(chatID, "Вас приветствует бот для автоматической отправки уведомлений о стримах.\n/help для просмотра доступных комманд!"))
 	case "help":
 		helpText := `📌 *Команды бота:*
 			/help — Показать справку
 			/new — ➕ Добавить Twitch-подписку
-			/list — 📋 Посмотреть ваши подписки`
+			/list — 📋 Посмотреть ваши подписки
+			/template — 📝 Настроить текст оповещения
+			/bind — 🔗 Привязать группу или тему (отправьте в группе)
+			/pro — 🌟 Подписка Pro
+			/payments — 💳 История платежей
+			/cancel — ✖️ Отменить текущее действие`
 		msg := tgbotapi.NewMessage(chatID, helpText)
 		msg.ParseMode = "Markdown"
-		bot.Send(msg)
+		h.bot.Send(msg)
 	case "new":
-		bot.Send(tgbotapi.NewMessage(chatID, "Напиши Twitch username:"))
-		userState[chatID] = "awaiting_username"
-		userData.TelegramID = update.Message.From.ID
-		userData.TelegramUsername = update.Message.From.UserName
+		h.bot.Send(tgbotapi.NewMessage(chatID, "Напиши Twitch username:"))
+		h.setConversation(key, Conversation{
+			State:            StateAwaitingUsername,
+			TelegramUsername: update.Message.From.UserName,
+		})
 	case "list":
-		subs, err := db.GetUserSubscriptions(update.Message.From.ID)
+		subs, err := h.db.GetUserSubscriptions(update.Message.From.ID)
 		if err != nil || len(subs) == 0 {
-			bot.Send(tgbotapi.NewMessage(chatID, "У вас пока нет добавленных Twitch-юзернеймов."))
+			h.bot.Send(tgbotapi.NewMessage(chatID, "У вас пока нет добавленных Twitch-юзернеймов."))
 			return
 		}
 		msgText, keyboard := buildSubscriptionPage(subs, 0)
 		msg := tgbotapi.NewMessage(chatID, msgText)
 		msg.ParseMode = "Markdown"
 		msg.ReplyMarkup = keyboard
-		bot.Send(msg)
+		h.bot.Send(msg)
 	case "delete":
-		bot.Send(tgbotapi.NewMessage(chatID, "Введите Twitch username, который вы хотите удалить:"))
-		userState[chatID] = "awaiting_delete_username"
+		h.bot.Send(tgbotapi.NewMessage(chatID, "Введите Twitch username, который вы хотите удалить:"))
+		h.setConversation(key, Conversation{State: StateAwaitingDeleteUsername})
+	case "cancel":
+		conv, err := h.conversations.Get(context.Background(), key)
+		if err == nil && conv.State == StateIdle {
+			h.bot.Send(tgbotapi.NewMessage(chatID, "Нечего отменять."))
+			return
+		}
+		h.endConversation(key)
+		h.bot.Send(tgbotapi.NewMessage(chatID, "✖️ Действие отменено."))
 	case "pro":
-		handleProCommand(bot, db, update)
+		h.handleProCommand(update)
+	case "payments":
+		h.handlePaymentsCommand(update)
+	case "template":
+		h.handleTemplateCommand(update)
+	case "bind":
+		h.handleBindCommand(update)
 	default:
-		bot.Send(tgbotapi.NewMessage(chatID, "Неизвестная команда"))
+		h.bot.Send(tgbotapi.NewMessage(chatID, "Неизвестная команда"))
 	}
 }
 
-func handleAwaitingUsername(bot *tgbotapi.BotAPI, db *database.DB, update tgbotapi.Update) {
+func (h *Handler) handleAwaitingUsername(update tgbotapi.Update, conv Conversation) {
 	chatID := update.Message.Chat.ID
-	userID := update.Message.From.ID
-	username := update.Message.From.UserName
-	userData.TelegramUsername = username
-	userData.TelegramID = userID
-	db.StoreData(userData, subscriptionData)
-	subscriptionData.TwitchUsername = strings.ToLower(strings.TrimSpace(update.Message.Text))
-	bot.Send(tgbotapi.NewMessage(chatID, "Перешлите сообщение из канала\nКанал должен быть открытым!"))
-	userState[chatID] = "awaiting_channel"
-}
+	key := conversationKey(update.Message)
 
-func handleAwaitingChannel(bot *tgbotapi.BotAPI, db *database.DB, update tgbotapi.Update) {
-	chatID := update.Message.Chat.ID
-	if update.Message.ForwardFromChat != nil && update.Message.ForwardFromChat.Type == "channel" {
-		subscriptionData.ChannelID = update.Message.ForwardFromChat.ID
-		subscriptionData.ChannelName = update.Message.ForwardFromChat.UserName
-		userState[chatID] = ""
+	login := normalizeTwitchLogin(update.Message.Text)
+	if !twitchLoginRegex.MatchString(login) {
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❗ Это не похоже на Twitch username. Попробуйте ещё раз:"))
+		return
+	}
 
-		subscriptionData.UserID = userData.TelegramID
-		err := db.StoreData(userData, subscriptionData)
-		if err != nil {
-			text := "Произошла ошибка при добавлении данных."
-			log.Println(err)
-			bot.Send(tgbotapi.NewMessage(chatID, text))
+	users, err := h.twitch.GetUsers(context.Background(), twitch.UsersQuery{Logins: []string{login}})
+	if err != nil {
+		log.Printf("Ошибка проверки Twitch username %s: %v", login, err)
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❗ Не удалось проверить username, Twitch недоступен. Попробуйте ещё раз позже."))
+		return
+	}
+	if len(users) == 0 {
+		conv.State = StateAwaitingUsername
+		h.setConversation(key, conv)
+		h.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("❗ Стример %s не найден на Twitch. Проверьте username и попробуйте ещё раз:", login)))
+		return
+	}
+	user := users[0]
+
+	conv.State = StateConfirmingUsername
+	conv.TwitchUsername = strings.ToLower(user.Login)
+	conv.TwitchUserID = user.ID
+	conv.TwitchName = user.DisplayName
+	h.setConversation(key, conv)
+
+	text := fmt.Sprintf("Это %s (twitch.tv/%s)?", user.DisplayName, conv.TwitchUsername)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Да", "streamer_confirm"),
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Другой username", "streamer_retry"),
+		),
+	)
+
+	if user.ProfileImageURL != "" {
+		photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileURL(user.ProfileImageURL))
+		photo.Caption = text
+		photo.ReplyMarkup = keyboard
+		if _, err := h.bot.Send(photo); err == nil {
 			return
 		}
+		log.Printf("Не удалось отправить аватар %s: %v", conv.TwitchUsername, err)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboard
+	h.bot.Send(msg)
+}
+
+// normalizeTwitchLogin принимает логин в виде "name", "@name" или ссылки на канал
+func normalizeTwitchLogin(text string) string {
+	login := strings.ToLower(strings.TrimSpace(text))
+	for _, prefix := range []string{"https://", "http://", "www.", "twitch.tv/", "@"} {
+		login = strings.TrimPrefix(login, prefix)
+	}
+	return strings.TrimSuffix(login, "/")
+}
+
+// handleStreamerConfirmation обрабатывает кнопки под найденным стримером в /new
+func (h *Handler) handleStreamerConfirmation(callback *tgbotapi.CallbackQuery) {
+	chatID := callback.Message.Chat.ID
+	key := ConversationKey{ChatID: chatID, UserID: callback.From.ID}
+
+	conv, err := h.conversations.Get(context.Background(), key)
+	if err != nil {
+		log.Printf("Ошибка получения диалога %d/%d: %v", key.ChatID, key.UserID, err)
+		return
+	}
+	if conv.State != StateConfirmingUsername || conv.Expired(time.Now()) {
+		h.bot.Send(tgbotapi.NewMessage(chatID, "⌛ Этот выбор уже неактуален. Начните заново: /new"))
+		return
+	}
 
-		text := fmt.Sprintf("Оповещения о стримах %s успешно добавлены в канал @%s", subscriptionData.TwitchUsername, subscriptionData.ChannelName)
-		bot.Send(tgbotapi.NewMessage(chatID, text))
-	} else {
-		bot.Send(tgbotapi.NewMessage(chatID, "Пожалуйста, перешлите сообщение из канала, чтобы я мог получить его ID."))
+	// Убираем кнопки, чтобы их нельзя было нажать повторно
+	h.bot.Send(tgbotapi.NewEditMessageReplyMarkup(chatID, callback.Message.MessageID,
+		tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}))
+
+	if callback.Data == "streamer_retry" {
+		conv.State = StateAwaitingUsername
+		conv.TwitchUsername = ""
+		conv.TwitchUserID = ""
+		conv.TwitchName = ""
+		h.setConversation(key, conv)
+		h.bot.Send(tgbotapi.NewMessage(chatID, "Напиши Twitch username:"))
+		return
 	}
+
+	conv.State = StateAwaitingChannel
+	h.setConversation(key, conv)
+	h.askForChat(chatID, key.UserID)
 }
 
-func handleAwaitingEmail(bot *tgbotapi.BotAPI, db *database.DB, update tgbotapi.Update) {
+func (h *Handler) handleAwaitingChannel(update tgbotapi.Update, conv Conversation) {
+	chatID := update.Message.Chat.ID
+	forwarded := update.Message.ForwardFromChat
+	if forwarded == nil || forwarded.Type != "channel" {
+		h.bot.Send(tgbotapi.NewMessage(chatID, "Пожалуйста, перешлите сообщение из канала или выберите чат кнопкой выше."))
+		return
+	}
+
+	target := database.ChatTarget{
+		UserID:   conversationKey(update.Message).UserID,
+		ChatID:   forwarded.ID,
+		ChatType: forwarded.Type,
+		Title:    chatTitle(forwarded),
+	}
+	h.subscribeToChat(conversationKey(update.Message), conv, target)
+}
+
+func (h *Handler) handleAwaitingEmail(update tgbotapi.Update, conv Conversation) {
 	chatID := update.Message.Chat.ID
 	email := strings.TrimSpace(update.Message.Text)
 
 	if !isValidEmail(email) {
-		bot.Send(tgbotapi.NewMessage(chatID, "❗ Пожалуйста, введите корректный email."))
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❗ Пожалуйста, введите корректный email."))
 		return
 	}
-	userData.TelegramID = update.Message.From.ID
-	userData.Email = email
+	userData := database.UserData{
+		TelegramID: update.Message.From.ID,
+		Email:      email,
+	}
 
-	db.UpdateUserEmail(userData)
+	h.db.UpdateUserEmail(userData)
 
-	userState[chatID] = ""
+	h.endConversation(conversationKey(update.Message))
+}
 
+func (h *Handler) handleTemplateCommand(update tgbotapi.Update) {
+	chatID := update.Message.Chat.ID
+	subs, err := h.db.GetUserSubscriptions(update.Message.From.ID)
+	if err != nil || len(subs) == 0 {
+		h.bot.Send(tgbotapi.NewMessage(chatID, "У вас пока нет добавленных Twitch-юзернеймов."))
+		return
+	}
+
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for _, sub := range subs {
+		text := fmt.Sprintf("%s → %s", sub.TwitchUsername, sub.ChannelName)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(text, fmt.Sprintf("template_sub_%d", sub.ID)),
+		))
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "Выберите подписку, текст оповещения которой хотите настроить:")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	h.bot.Send(msg)
 }
 
-func handleProCommand(bot *tgbotapi.BotAPI, db *database.DB, update tgbotapi.Update) {
+func (h *Handler) handleTemplateCallback(callback *tgbotapi.CallbackQuery) {
+	chatID := callback.Message.Chat.ID
+	messageID := callback.Message.MessageID
+	userID := callback.From.ID
+
+	action, idStr, _ := strings.Cut(strings.TrimPrefix(callback.Data, "template_"), "_")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("Неверный ID подписки: %v", err)
+		return
+	}
+
+	sub := h.findUserSubscription(userID, id)
+	if sub == nil {
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❗ Подписка не найдена."))
+		return
+	}
+
+	switch action {
+	case "sub":
+		current := sub.Template
+		if current == "" {
+			current = defaultAnnouncementTemplate + "\n\n(шаблон по умолчанию)"
+		}
+		text := fmt.Sprintf("Шаблон оповещения %s → %s:\n\n%s", sub.TwitchUsername, sub.ChannelName, current)
+		edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, text,
+			tgbotapi.NewInlineKeyboardMarkup(
+				tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("✏️ Изменить", fmt.Sprintf("template_set_%d", sub.ID)),
+					tgbotapi.NewInlineKeyboardButtonData("👁 Предпросмотр", fmt.Sprintf("template_preview_%d", sub.ID)),
+				),
+				tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("♻️ Сбросить", fmt.Sprintf("template_reset_%d", sub.ID)),
+				),
+			),
+		)
+		h.bot.Send(edit)
+
+	case "set":
+		h.setConversation(ConversationKey{ChatID: chatID, UserID: userID}, Conversation{
+			State:                  StateAwaitingTemplate,
+			TemplateSubscriptionID: sub.ID,
+		})
+		text := "Отправьте новый шаблон оповещения. Текст размечается MarkdownV2: служебные символы вне подстановок нужно экранировать через \\.\n\nДоступные подстановки:\n" + announcementPlaceholders
+		h.bot.Send(tgbotapi.NewMessage(chatID, text))
+
+	case "preview":
+		h.sendTemplatePreview(chatID, *sub)
+
+	case "reset":
+		if err := h.db.UpdateSubscriptionTemplate(sub.ID, userID, ""); err != nil {
+			log.Printf("Ошибка сброса шаблона: %v", err)
+			h.bot.Send(tgbotapi.NewMessage(chatID, "❗ Не удалось сбросить шаблон."))
+			return
+		}
+		h.bot.Send(tgbotapi.NewEditMessageText(chatID, messageID, "✅ Шаблон сброшен на стандартный."))
+	}
+}
+
+// handleAwaitingDeleteUsername предлагает удалить подписки на введённый
+// Twitch-ник; удаление подтверждается кнопкой, как из списка подписок
+func (h *Handler) handleAwaitingDeleteUsername(update tgbotapi.Update) {
+	chatID := update.Message.Chat.ID
+	username := strings.TrimPrefix(strings.TrimSpace(update.Message.Text), "@")
+	h.endConversation(conversationKey(update.Message))
+
+	subs, err := h.db.GetUserSubscriptions(update.Message.From.ID)
+	if err != nil {
+		log.Printf("Ошибка получения подписок: %v", err)
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❗ Не удалось получить подписки."))
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, sub := range subs {
+		if strings.EqualFold(sub.TwitchUsername, username) {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(
+					fmt.Sprintf("❌ %s → %s", sub.TwitchUsername, sub.ChannelName),
+					fmt.Sprintf("delete_sub_%d", sub.ID),
+				),
+			))
+		}
+	}
+	if len(rows) == 0 {
+		h.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("❗ Подписка на %s не найдена.", username)))
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "Выберите подписку, которую хотите удалить:")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	h.bot.Send(msg)
+}
+
+func (h *Handler) handleAwaitingTemplate(update tgbotapi.Update, conv Conversation) {
 	chatID := update.Message.Chat.ID
 	userID := update.Message.From.ID
+	key := conversationKey(update.Message)
 
-	description := `🌟 *Подписка Pro* даёт вам:
-- 🔔 Уведомления без ограничений
-- 📈 Приоритетную обработку запросов
-- 🚫 Отключение всей рекламы
-Стоимость — всего *50₽ в месяц*`
+	sub := h.findUserSubscription(userID, conv.TemplateSubscriptionID)
+	if sub == nil {
+		h.endConversation(key)
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❗ Подписка не найдена."))
+		return
+	}
+
+	text := update.Message.Text
+	if err := validateAnnouncementTemplate(text, sub.TwitchUsername); err != nil {
+		h.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("❗ Шаблон не сохранён: %v\nИсправьте шаблон и отправьте его ещё раз.", err)))
+		return
+	}
+
+	if err := h.db.UpdateSubscriptionTemplate(sub.ID, userID, text); err != nil {
+		log.Printf("Ошибка сохранения шаблона: %v", err)
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❗ Не удалось сохранить шаблон."))
+		return
+	}
+
+	h.endConversation(key)
+	h.bot.Send(tgbotapi.NewMessage(chatID, "✅ Шаблон сохранён. Так будет выглядеть оповещение:"))
 
-	isPro, expiry, err := db.IsUserPro(userID)
+	sub.Template = text
+	h.sendTemplatePreview(chatID, *sub)
+}
+
+func (h *Handler) sendTemplatePreview(chatID int64, sub database.SubscriptionData) {
+	text, err := renderAnnouncement(sub.Template, sampleAnnouncementData(sub.TwitchUsername))
 	if err != nil {
-		log.Printf("DB error: %v", err)
-		bot.Send(tgbotapi.NewMessage(chatID, "❗ Ошибка при проверке статуса. Попробуйте позже."))
+		h.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("❗ Ошибка в шаблоне: %v", err)))
 		return
 	}
 
-	if isPro {
-		text := fmt.Sprintf("%s\n\n✅ У вас уже активна подписка *Pro* до *%s*.", description, expiry.Format("02.01.2006"))
-		msg := tgbotapi.NewMessage(chatID, text)
-		msg.ParseMode = "Markdown"
-		bot.Send(msg)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "MarkdownV2"
+	if _, err := h.bot.Send(msg); err != nil {
+		h.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("❗ Telegram не принял шаблон: %v", err)))
+	}
+}
+
+const proDescription = `🌟 *Подписка Pro* даёт вам:
+- 🔔 Уведомления без ограничений
+- 📈 Приоритетную обработку запросов
+- 🚫 Отключение всей рекламы`
+
+func (h *Handler) handleProCommand(update tgbotapi.Update) {
+	chatID := update.Message.Chat.ID
+	userID := update.Message.From.ID
+
+	isPro, expiry, err := h.db.IsUserPro(userID)
+	if err != nil {
+		log.Printf("DB error: %v", err)
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❗ Ошибка при проверке статуса. Попробуйте позже."))
 		return
 	}
 
-	email, err := db.GetUserEmail(userID)
+	email, err := h.db.GetUserEmail(userID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "Пожалуйста, введите email")
-		bot.Send(msg)
-		userState[chatID] = "awaiting_email"
+		h.bot.Send(msg)
+		h.setConversation(conversationKey(update.Message), Conversation{State: StateAwaitingEmail})
 		return
 	}
 
 	if email == "" {
 		msg := tgbotapi.NewMessage(chatID, "❗ Email не может быть пустым. Пожалуйста, добавьте email в профиле и попробуйте снова.")
-		bot.Send(msg)
+		h.bot.Send(msg)
+		return
+	}
+
+	text := proDescription
+	if isPro {
+		text += fmt.Sprintf("\n\n✅ У вас активна подписка *Pro* до *%s*. Продление добавится к оставшемуся сроку.", expiry.Format("02.01.2006"))
+	}
+	text += "\n\nВыберите срок подписки:"
+
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for _, plan := range h.payments.Plans {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.planTitle(plan), "pro_plan_"+plan.ID),
+		))
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	h.bot.Send(msg)
+}
+
+// handleProPlanCallback создаёт платёж за выбранный тариф и показывает ссылку на оплату
+func (h *Handler) handleProPlanCallback(callback *tgbotapi.CallbackQuery) {
+	chatID := callback.Message.Chat.ID
+	userID := callback.From.ID
+
+	plan, ok := h.payments.Plan(strings.TrimPrefix(callback.Data, "pro_plan_"))
+	if !ok {
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❗ Тариф не найден. Откройте /pro ещё раз."))
+		return
+	}
+
+	email, err := h.db.GetUserEmail(userID)
+	if err != nil || email == "" {
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❗ Не найден email для чека. Откройте /pro ещё раз."))
 		return
 	}
 
-	client := yookassa.NewClient()
-	payURL, err := client.CreatePayment(userID, email)
+	payment, err := h.payments.CreatePayment(context.Background(), userID, email, plan)
 	if err != nil {
 		log.Printf("YooKassa error (user %d): %v", userID, err)
-		bot.Send(tgbotapi.NewMessage(chatID, "❗ Ошибка при создании платежа. Попробуйте позже."))
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❗ Ошибка при создании платежа. Попробуйте позже."))
 		return
 	}
 
-	amount := "50₽"
-	msgText := fmt.Sprintf("%s\n\n💳 Нажмите кнопку ниже, чтобы оплатить *%s* и активировать подписку:", description, amount)
+	err = h.db.CreatePayment(context.Background(), database.Payment{
+		ID:             payment.ID,
+		UserID:         userID,
+		Amount:         payment.Amount.Value,
+		Currency:       payment.Amount.Currency,
+		Months:         plan.Months,
+		Status:         payment.Status,
+		IdempotenceKey: payment.IdempotenceKey,
+		Payload:        payment.Raw,
+	})
+	if err != nil {
+		// Ссылку всё равно отдаём: вебхук добавит платёж, если записи нет
+		log.Print(err)
+	}
 
-	button := tgbotapi.NewInlineKeyboardButtonURL("Оплатить "+amount, payURL)
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(button),
-	)
+	amount := h.payments.PriceText(plan.Price)
+	msgText := fmt.Sprintf("%s\n\n💳 Нажмите кнопку ниже, чтобы оплатить *%s* за %s и активировать подписку:",
+		proDescription, amount, monthsText(plan.Months))
 
-	msg := tgbotapi.NewMessage(chatID, msgText)
-	msg.ParseMode = "Markdown"
-	msg.ReplyMarkup = keyboard
+	button := tgbotapi.NewInlineKeyboardButtonURL("Оплатить "+amount, payment.Confirmation.URL)
+	edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, callback.Message.MessageID, msgText,
+		tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(button)))
+	edit.ParseMode = "Markdown"
+	h.bot.Send(edit)
+}
+
+func (h *Handler) planTitle(plan yookassa.Plan) string {
+	return fmt.Sprintf("%s — %s", monthsText(plan.Months), h.payments.PriceText(plan.Price))
+}
+
+// monthsText склоняет срок: 1 месяц, 3 месяца, 12 месяцев
+func monthsText(months int) string {
+	switch {
+	case months%10 == 1 && months%100 != 11:
+		return fmt.Sprintf("%d месяц", months)
+	case months%10 >= 2 && months%10 <= 4 && (months%100 < 12 || months%100 > 14):
+		return fmt.Sprintf("%d месяца", months)
+	default:
+		return fmt.Sprintf("%d месяцев", months)
+	}
+}
 
-	bot.Send(msg)
+// paymentsHistoryLimit — сколько последних платежей показывает /payments
+const paymentsHistoryLimit = 20
+
+var paymentStatusNames = map[string]string{
+	database.PaymentPending:           "⏳ ожидает оплаты",
+	database.PaymentWaitingForCapture: "⏳ ожидает подтверждения",
+	database.PaymentSucceeded:         "✅ оплачен",
+	database.PaymentCanceled:          "✖️ отменён",
+}
+
+func (h *Handler) handlePaymentsCommand(update tgbotapi.Update) {
+	chatID := update.Message.Chat.ID
+
+	payments, err := h.db.GetUserPayments(context.Background(), update.Message.From.ID, paymentsHistoryLimit)
+	if err != nil {
+		log.Printf("DB error: %v", err)
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❗ Не удалось получить историю платежей. Попробуйте позже."))
+		return
+	}
+	if len(payments) == 0 {
+		h.bot.Send(tgbotapi.NewMessage(chatID, "У вас пока нет платежей. Оформить подписку: /pro"))
+		return
+	}
+
+	h.bot.Send(tgbotapi.NewMessage(chatID, formatPayments(payments)))
+}
+
+func formatPayments(payments []database.Payment) string {
+	var b strings.Builder
+	b.WriteString("💳 Ваши платежи:\n")
+	for _, p := range payments {
+		status, ok := paymentStatusNames[p.Status]
+		if !ok {
+			status = p.Status
+		}
+		fmt.Fprintf(&b, "\n%s — %s — %s %s — %s", p.CreatedAt.Format("02.01.2006 15:04"), monthsText(p.Months), p.Amount, p.Currency, status)
+	}
+	return b.String()
 }
 
-func StartProExpiryChecker(bot *tgbotapi.BotAPI, db *database.DB, interval time.Duration) {
+func StartProExpiryChecker(ctx context.Context, queue *SendQueue, db *database.DB, interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	go func() {
-		for range ticker.C {
-			err := db.RemoveExpiredProUsers(bot)
-			if err != nil {
-				log.Printf("❗ Ошибка при удалении просроченных подписок: %v", err)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				expired, err := db.RemoveExpiredProUsers(ctx)
+				if err != nil {
+					log.Printf("❗ Ошибка при удалении просроченных подписок: %v", err)
+				}
+				for _, userID := range expired {
+					if err := queue.Notify(userID, "❌ Ваша подписка Pro истекла."); err != nil {
+						log.Printf("Не удалось отправить сообщение %d: %v", userID, err)
+					}
+				}
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
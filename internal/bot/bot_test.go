@@ -0,0 +1,50 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"twitchannouncer/internal/database"
+)
+
+func TestNormalizeTwitchLogin(t *testing.T) {
+	for input, want := range map[string]string{
+		"Streamer_1":                      "streamer_1",
+		"  @streamer ":                    "streamer",
+		"https://www.twitch.tv/Streamer/": "streamer",
+		"twitch.tv/streamer":              "streamer",
+	} {
+		login := normalizeTwitchLogin(input)
+		assert.Equal(t, want, login, input)
+		assert.True(t, twitchLoginRegex.MatchString(login), input)
+	}
+
+	assert.False(t, twitchLoginRegex.MatchString(normalizeTwitchLogin("не логин")))
+}
+
+func TestFormatPayments(t *testing.T) {
+	created := time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC)
+	text := formatPayments([]database.Payment{
+		{ID: "a", Amount: "50.00", Currency: "RUB", Months: 1, Status: database.PaymentSucceeded, CreatedAt: created},
+		{ID: "b", Amount: "135.00", Currency: "RUB", Months: 3, Status: "refunded", CreatedAt: created},
+	})
+
+	lines := strings.Split(text, "\n")
+	assert.Equal(t, "01.03.2025 12:30 — 1 месяц — 50.00 RUB — ✅ оплачен", lines[2])
+	// Неизвестный статус показываем как есть
+	assert.Equal(t, "01.03.2025 12:30 — 3 месяца — 135.00 RUB — refunded", lines[3])
+}
+
+func TestMonthsText(t *testing.T) {
+	for months, want := range map[int]string{
+		1:  "1 месяц",
+		3:  "3 месяца",
+		12: "12 месяцев",
+		21: "21 месяц",
+		24: "24 месяца",
+	} {
+		assert.Equal(t, want, monthsText(months))
+	}
+}
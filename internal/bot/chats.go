@@ -0,0 +1,492 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"twitchannouncer/internal/database"
+)
+
+// Оповещения можно отправлять в каналы (в том числе закрытые), группы и темы
+// форумов. Канал добавляется пересылкой сообщения из него в /new, группа —
+// добавлением бота или командой /bind, тема форума — командой /bind <тема>.
+
+// askForChat спрашивает, куда отправлять оповещения, и предлагает чаты,
+// добавленные пользователем раньше
+func (h *Handler) askForChat(chatID, userID int64) {
+	text := "Куда отправлять оповещения?\n\n" +
+		"📢 Канал — перешлите сюда любое сообщение из него, подойдёт и закрытый канал.\n" +
+		"👥 Группа — добавьте в неё бота или отправьте в группе /bind. Для темы форума — /bind <ссылка на тему>."
+
+	targets, err := h.db.GetUserChatTargets(context.Background(), userID)
+	if err != nil {
+		log.Print(err)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	if len(targets) > 0 {
+		msg.Text += "\n\nИли выберите чат, добавленный ранее:"
+		rows := [][]tgbotapi.InlineKeyboardButton{}
+		for _, t := range targets {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(targetLabel(t), fmt.Sprintf("target_%d_%d", t.ChatID, t.ThreadID)),
+			))
+		}
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+	h.bot.Send(msg)
+}
+
+func (h *Handler) handleChatTargetCallback(callback *tgbotapi.CallbackQuery) {
+	chatID := callback.Message.Chat.ID
+	key := ConversationKey{ChatID: chatID, UserID: callback.From.ID}
+
+	conv, err := h.conversations.Get(context.Background(), key)
+	if err != nil {
+		log.Printf("Ошибка получения диалога %d/%d: %v", key.ChatID, key.UserID, err)
+		return
+	}
+	if conv.State != StateAwaitingChannel || conv.Expired(time.Now()) {
+		h.bot.Send(tgbotapi.NewMessage(chatID, "⌛ Этот выбор уже неактуален. Начните заново: /new"))
+		return
+	}
+
+	targetChatStr, threadStr, _ := strings.Cut(strings.TrimPrefix(callback.Data, "target_"), "_")
+	targetChatID, err1 := strconv.ParseInt(targetChatStr, 10, 64)
+	threadID, err2 := strconv.Atoi(threadStr)
+	if err1 != nil || err2 != nil {
+		log.Printf("Неверные данные выбора чата: %s", callback.Data)
+		return
+	}
+
+	// Выбрать можно только чат из своего списка
+	targets, err := h.db.GetUserChatTargets(context.Background(), key.UserID)
+	if err != nil {
+		log.Print(err)
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❗ Не удалось получить список чатов. Попробуйте позже."))
+		return
+	}
+	for _, target := range targets {
+		if target.ChatID == targetChatID && target.ThreadID == threadID {
+			h.bot.Send(tgbotapi.NewEditMessageReplyMarkup(chatID, callback.Message.MessageID,
+				tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}))
+			h.subscribeToChat(key, conv, target)
+			return
+		}
+	}
+	h.bot.Send(tgbotapi.NewMessage(chatID, "❗ Чат не найден. Начните заново: /new"))
+}
+
+// subscribeToChat сохраняет подписку из диалога /new на выбранный чат
+func (h *Handler) subscribeToChat(key ConversationKey, conv Conversation, target database.ChatTarget) {
+	// Без прав бот не сможет публиковать оповещения, и ошибки будут видны
+	// только в логах, поэтому проверяем права сразу
+	if problems := h.checkChatRights(target.ChatType, target.ChatID, key.UserID); len(problems) > 0 {
+		text := fmt.Sprintf("❗ Не могу отправлять оповещения в «%s»:\n• %s\n\nИсправьте это и попробуйте ещё раз.",
+			targetName(target), strings.Join(problems, "\n• "))
+		h.bot.Send(tgbotapi.NewMessage(key.ChatID, text))
+		return
+	}
+
+	userData := database.UserData{
+		TelegramID:       key.UserID,
+		TelegramUsername: conv.TelegramUsername,
+	}
+	subscriptionData := database.SubscriptionData{
+		UserID:            key.UserID,
+		ChannelID:         target.ChatID,
+		ChannelName:       targetName(target),
+		MessageThreadID:   target.ThreadID,
+		TwitchUsername:    conv.TwitchUsername,
+		TwitchUserID:      conv.TwitchUserID,
+		TwitchDisplayName: conv.TwitchName,
+	}
+	h.endConversation(key)
+
+	err := h.db.StoreData(userData, subscriptionData)
+	if err != nil {
+		log.Println(err)
+		h.bot.Send(tgbotapi.NewMessage(key.ChatID, "Произошла ошибка при добавлении данных."))
+		return
+	}
+
+	// Пересланный канал тоже запоминаем, чтобы в следующий раз выбрать его кнопкой
+	if err := h.db.SaveChatTarget(context.Background(), target); err != nil {
+		log.Print(err)
+	}
+
+	text := fmt.Sprintf("Оповещения о стримах %s успешно добавлены в «%s»", subscriptionData.TwitchUsername, subscriptionData.ChannelName)
+	h.bot.Send(tgbotapi.NewMessage(key.ChatID, text))
+}
+
+// handleBindCommand привязывает группу или тему форума, в которой вызвана команда
+func (h *Handler) handleBindCommand(update tgbotapi.Update) {
+	msg := update.Message
+	if msg.Chat.Type != "group" && msg.Chat.Type != "supergroup" {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Отправьте /bind в группе, куда нужно присылать оповещения. "+
+			"Для темы форума добавьте ссылку на тему или её номер: /bind https://t.me/c/123/45\n"+
+			"Канал можно добавить, переслав из него сообщение во время /new."))
+		return
+	}
+	if msg.From == nil {
+		return
+	}
+	if isAnonymousSender(msg) {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❗ Команда отправлена анонимно, и я не могу проверить, кто её отправил. "+
+			"Отправьте /bind от своего имени: отключите «Анонимность» в правах администратора или попросите другого администратора."))
+		return
+	}
+
+	threadID, err := parseTopicID(msg.CommandArguments())
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❗ "+err.Error()+". Укажите ссылку на тему или её номер: /bind 45"))
+		return
+	}
+
+	target := database.ChatTarget{
+		UserID:   msg.From.ID,
+		ChatID:   msg.Chat.ID,
+		ThreadID: threadID,
+		ChatType: msg.Chat.Type,
+		Title:    chatTitle(msg.Chat),
+	}
+	if problems := h.checkChatRights(target.ChatType, target.ChatID, target.UserID); len(problems) > 0 {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❗ Не могу привязать этот чат:\n• "+strings.Join(problems, "\n• ")))
+		return
+	}
+
+	if err := h.db.SaveChatTarget(context.Background(), target); err != nil {
+		log.Print(err)
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❗ Не удалось привязать чат. Попробуйте позже."))
+		return
+	}
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ «%s» привязан. Выберите его в /new в личных сообщениях с ботом.", targetName(target))))
+}
+
+// handleMyChatMember ставит на паузу подписки на чат, из которого удалили бота
+// или где у него забрали права, и включает их обратно, когда права вернули.
+// Кроме того, запоминает чат, в который пользователь добавил бота.
+func (h *Handler) handleMyChatMember(update *tgbotapi.ChatMemberUpdated) {
+	if update.Chat.Type == "private" {
+		return
+	}
+
+	if problems := botRightsProblems(update.Chat.Type, update.NewChatMember); len(problems) > 0 {
+		h.pauseChatSubscriptions(update.Chat, strings.Join(problems, ", "))
+	} else {
+		h.resumeChatSubscriptions(update.Chat)
+	}
+
+	if update.From.IsBot || !isChatMember(update.NewChatMember.Status) {
+		return
+	}
+
+	target := database.ChatTarget{
+		UserID:   update.From.ID,
+		ChatID:   update.Chat.ID,
+		ChatType: update.Chat.Type,
+		Title:    chatTitle(&update.Chat),
+	}
+	if err := h.db.SaveChatTarget(context.Background(), target); err != nil {
+		log.Print(err)
+		return
+	}
+	log.Printf("Бот добавлен в чат %d (%s) пользователем %d", target.ChatID, target.ChatType, target.UserID)
+
+	// Изменение прав в чате, где бот уже был, не повод писать пользователю
+	if isChatMember(update.OldChatMember.Status) {
+		return
+	}
+	text := fmt.Sprintf("✅ Бот добавлен в «%s». Теперь этот чат можно выбрать в /new.", target.Title)
+	if err := h.queue.Notify(target.UserID, text); err != nil {
+		// Пользователь мог ни разу не писать боту
+		log.Printf("Не удалось сообщить пользователю %d о добавлении в чат: %v", target.UserID, err)
+	}
+}
+
+func (h *Handler) pauseChatSubscriptions(chat tgbotapi.Chat, reason string) {
+	subs, err := h.db.PauseChatSubscriptions(context.Background(), chat.ID, reason)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if len(subs) == 0 {
+		return
+	}
+	log.Printf("Подписки на чат %d приостановлены (%d шт.): %s", chat.ID, len(subs), reason)
+
+	for userID, userSubs := range groupByOwner(subs) {
+		text, keyboard := buildPauseNotice(chatTitle(&chat), reason, userSubs)
+		msg := tgbotapi.NewMessage(userID, text)
+		msg.ReplyMarkup = keyboard
+		if _, err := h.queue.send(userID, priorityMessage, msg); err != nil {
+			log.Printf("Не удалось сообщить пользователю %d о паузе подписок: %v", userID, err)
+		}
+	}
+}
+
+// resumeChatSubscriptions включает подписки, поставленные на паузу из-за прав
+// бота. Паузы после ошибок отправки (закрытая тема, миграция группы)
+// изменение прав не исправляет, их владелец включает сам.
+func (h *Handler) resumeChatSubscriptions(chat tgbotapi.Chat) {
+	ctx := context.Background()
+	chatSubs, err := h.db.GetChatSubscriptions(ctx, chat.ID)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	ids := resumableAfterRightsUpdate(chatSubs)
+	if len(ids) == 0 {
+		return
+	}
+
+	subs, err := h.db.ResumeSubscriptions(ctx, ids, database.PauseSourceChatMember)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if len(subs) == 0 {
+		return
+	}
+	log.Printf("Подписки на чат %d снова включены (%d шт.)", chat.ID, len(subs))
+
+	for userID, userSubs := range groupByOwner(subs) {
+		names := make([]string, 0, len(userSubs))
+		for _, sub := range userSubs {
+			names = append(names, sub.TwitchUsername)
+		}
+		text := fmt.Sprintf("▶️ Бот снова может писать в «%s», оповещения включены: %s",
+			chatTitle(&chat), strings.Join(names, ", "))
+		if err := h.queue.Notify(userID, text); err != nil {
+			log.Printf("Не удалось сообщить пользователю %d о включении подписок: %v", userID, err)
+		}
+	}
+}
+
+// resumableAfterRightsUpdate — ID подписок, которые включаются сами, когда
+// бот снова может писать в чат
+func resumableAfterRightsUpdate(subs []database.SubscriptionData) []int {
+	var ids []int
+	for _, sub := range subs {
+		if sub.Paused && sub.PauseSource == database.PauseSourceChatMember {
+			ids = append(ids, sub.ID)
+		}
+	}
+	return ids
+}
+
+// handleResumeCallback включает подписку кнопкой из уведомления о паузе или
+// из меню подписки, если бот снова может писать в чат
+func (h *Handler) handleResumeCallback(callback *tgbotapi.CallbackQuery, sub database.SubscriptionData) {
+	chatID := callback.Message.Chat.ID
+
+	var problems []string
+	chat, err := h.bot.GetChat(tgbotapi.ChatInfoConfig{ChatConfig: tgbotapi.ChatConfig{ChatID: sub.ChannelID}})
+	if err != nil {
+		log.Printf("Не удалось получить чат %d: %v", sub.ChannelID, err)
+		problems = []string{"бот не добавлен в чат"}
+	} else {
+		problems = h.checkChatRights(chat.Type, sub.ChannelID, callback.From.ID)
+	}
+	if len(problems) > 0 {
+		text := fmt.Sprintf("❗ Пока не могу включить оповещения в «%s»:\n• %s\n\nИсправьте это, и подписка включится сама.",
+			sub.ChannelName, strings.Join(problems, "\n• "))
+		h.bot.Send(tgbotapi.NewMessage(chatID, text))
+		return
+	}
+
+	if err := h.db.ResumeSubscription(context.Background(), sub.ID, callback.From.ID); err != nil {
+		log.Print(err)
+		h.bot.Send(tgbotapi.NewCallback(callback.ID, "Ошибка при включении подписки"))
+		return
+	}
+
+	if callback.Message.ReplyMarkup != nil {
+		keyboard := withoutButton(*callback.Message.ReplyMarkup, callback.Data)
+		h.bot.Send(tgbotapi.NewEditMessageReplyMarkup(chatID, callback.Message.MessageID, keyboard))
+	}
+	h.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("▶️ Оповещения %s → %s снова включены.", sub.TwitchUsername, sub.ChannelName)))
+}
+
+// buildPauseNotice — уведомление владельцу о паузе его подписок на чат
+func buildPauseNotice(title, reason string, subs []database.SubscriptionData) (string, tgbotapi.InlineKeyboardMarkup) {
+	var text strings.Builder
+	fmt.Fprintf(&text, "⏸ Оповещения в «%s» приостановлены: %s.\n\n", title, reason)
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for _, sub := range subs {
+		fmt.Fprintf(&text, "• %s → %s\n", sub.TwitchUsername, sub.ChannelName)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Включить снова: "+sub.TwitchUsername, fmt.Sprintf("sub_resume_%d", sub.ID)),
+		))
+	}
+	text.WriteString("\nИсправьте это и нажмите кнопку. Если вернуть бота в чат с нужными правами, подписки включатся сами.")
+	return text.String(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func groupByOwner(subs []database.SubscriptionData) map[int64][]database.SubscriptionData {
+	byOwner := make(map[int64][]database.SubscriptionData)
+	for _, sub := range subs {
+		byOwner[sub.UserID] = append(byOwner[sub.UserID], sub)
+	}
+	return byOwner
+}
+
+// withoutButton убирает из клавиатуры кнопку с данными data
+func withoutButton(keyboard tgbotapi.InlineKeyboardMarkup, data string) tgbotapi.InlineKeyboardMarkup {
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for _, row := range keyboard.InlineKeyboard {
+		var kept []tgbotapi.InlineKeyboardButton
+		for _, button := range row {
+			if button.CallbackData == nil || *button.CallbackData != data {
+				kept = append(kept, button)
+			}
+		}
+		if len(kept) > 0 {
+			rows = append(rows, kept)
+		}
+	}
+	return tgbotapi.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// groupAnonymousBotID — From сообщений, которые администратор группы
+// отправил анонимно от имени группы
+const groupAnonymousBotID = 1087968824
+
+// isAnonymousSender сообщает, что сообщение отправлено от имени чата:
+// анонимным администратором или из связанного канала. Настоящий автор
+// в таком сообщении неизвестен.
+func isAnonymousSender(msg *tgbotapi.Message) bool {
+	return msg.SenderChat != nil || (msg.From != nil && msg.From.ID == groupAnonymousBotID)
+}
+
+func isChatMember(status string) bool {
+	return status == "creator" || status == "administrator" || status == "member" || status == "restricted"
+}
+
+// checkChatRights проверяет, что бот может публиковать в чате, а пользователь —
+// администратор чата. Возвращает понятные пользователю описания того, чего не хватает.
+func (h *Handler) checkChatRights(chatType string, chatID, userID int64) []string {
+	botMember, err := h.bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: h.bot.Self.ID},
+	})
+	if err != nil {
+		// Telegram не отдаёт участников канала, в котором бот не администратор
+		log.Printf("Не удалось получить права бота в чате %d: %v", chatID, err)
+		return []string{"бот не добавлен в чат как администратор"}
+	}
+
+	userMember, err := h.bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID},
+	})
+	if err != nil {
+		log.Printf("Не удалось получить права пользователя %d в чате %d: %v", userID, chatID, err)
+		return []string{"не удалось проверить, что вы администратор чата"}
+	}
+
+	return chatRightsProblems(chatType, botMember, userMember)
+}
+
+// chatRightsProblems: в канале боту нужны права публиковать и удалять
+// сообщения, в группе достаточно права писать — свои сообщения бот удалить может
+func chatRightsProblems(chatType string, botMember, userMember tgbotapi.ChatMember) []string {
+	problems := botRightsProblems(chatType, botMember)
+	if userMember.Status != "creator" && userMember.Status != "administrator" {
+		if chatType == "channel" {
+			problems = append(problems, "вы не администратор этого канала")
+		} else {
+			problems = append(problems, "вы не администратор этой группы")
+		}
+	}
+	return problems
+}
+
+func botRightsProblems(chatType string, botMember tgbotapi.ChatMember) []string {
+	var problems []string
+	if chatType == "channel" {
+		if botMember.Status != "administrator" {
+			problems = append(problems, "бот не добавлен в канал как администратор")
+		} else {
+			if !botMember.CanPostMessages {
+				problems = append(problems, "у бота нет права «Публикация сообщений»")
+			}
+			if !botMember.CanDeleteMessages {
+				problems = append(problems, "у бота нет права «Удаление чужих сообщений»")
+			}
+		}
+		return problems
+	}
+
+	switch {
+	case !isChatMember(botMember.Status):
+		problems = append(problems, "бот не добавлен в группу")
+	case botMember.Status == "restricted" && !botMember.CanSendMessages:
+		problems = append(problems, "боту запрещено писать в группе")
+	}
+	return problems
+}
+
+// parseTopicID достаёт номер темы форума из аргумента /bind: номера или
+// ссылки вида https://t.me/c/123/45, https://t.me/group/45 или ссылки на
+// сообщение в теме https://t.me/c/123/45/678. Пустой аргумент — весь чат.
+func parseTopicID(arg string) (int, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return 0, nil
+	}
+	if id, err := strconv.Atoi(arg); err == nil && id > 0 {
+		return id, nil
+	}
+
+	link := strings.TrimPrefix(strings.TrimPrefix(arg, "https://"), "http://")
+	path, ok := strings.CutPrefix(link, "t.me/")
+	if !ok {
+		return 0, fmt.Errorf("не удалось найти номер темы в %q", arg)
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	// Ссылки на закрытые группы начинаются с c/<id>, на открытые — с имени группы
+	if parts[0] == "c" && len(parts) > 1 {
+		parts = parts[2:]
+	} else {
+		parts = parts[1:]
+	}
+	if len(parts) == 0 {
+		return 0, fmt.Errorf("не удалось найти номер темы в %q", arg)
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("не удалось найти номер темы в %q", arg)
+	}
+	return id, nil
+}
+
+// chatTitle — название чата; у чатов без названия — @username или ID
+func chatTitle(chat *tgbotapi.Chat) string {
+	switch {
+	case chat.Title != "":
+		return chat.Title
+	case chat.UserName != "":
+		return "@" + chat.UserName
+	default:
+		return strconv.FormatInt(chat.ID, 10)
+	}
+}
+
+// targetName — название чата с номером темы, сохраняется в подписке
+func targetName(t database.ChatTarget) string {
+	if t.ThreadID != 0 {
+		return fmt.Sprintf("%s / тема %d", t.Title, t.ThreadID)
+	}
+	return t.Title
+}
+
+func targetLabel(t database.ChatTarget) string {
+	if t.ChatType == "channel" {
+		return "📢 " + targetName(t)
+	}
+	return "👥 " + targetName(t)
+}
@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/assert"
+	"twitchannouncer/internal/database"
+)
+
+func TestChatRightsProblems(t *testing.T) {
+	admin := tgbotapi.ChatMember{Status: "administrator", CanPostMessages: true, CanDeleteMessages: true}
+	owner := tgbotapi.ChatMember{Status: "creator"}
+	member := tgbotapi.ChatMember{Status: "member"}
+
+	assert.Empty(t, chatRightsProblems("channel", admin, owner))
+	assert.Empty(t, chatRightsProblems("channel", admin, admin))
+
+	noDelete := admin
+	noDelete.CanDeleteMessages = false
+	assert.Equal(t, []string{"у бота нет права «Удаление чужих сообщений»"}, chatRightsProblems("channel", noDelete, owner))
+
+	assert.Equal(t, []string{"бот не добавлен в канал как администратор", "вы не администратор этого канала"},
+		chatRightsProblems("channel", member, member))
+
+	// В группе боту достаточно быть участником
+	assert.Empty(t, chatRightsProblems("supergroup", member, owner))
+	assert.Equal(t, []string{"боту запрещено писать в группе"},
+		chatRightsProblems("group", tgbotapi.ChatMember{Status: "restricted"}, owner))
+	assert.Equal(t, []string{"бот не добавлен в группу", "вы не администратор этой группы"},
+		chatRightsProblems("group", tgbotapi.ChatMember{Status: "kicked"}, member))
+}
+
+func TestBotRightsProblems(t *testing.T) {
+	// Бота удалили из канала или лишили права публиковать — подписки на паузу
+	assert.Equal(t, []string{"бот не добавлен в канал как администратор"},
+		botRightsProblems("channel", tgbotapi.ChatMember{Status: "kicked"}))
+	assert.Equal(t, []string{"у бота нет права «Публикация сообщений»"},
+		botRightsProblems("channel", tgbotapi.ChatMember{Status: "administrator", CanDeleteMessages: true}))
+	assert.Equal(t, []string{"бот не добавлен в группу"},
+		botRightsProblems("supergroup", tgbotapi.ChatMember{Status: "left"}))
+	assert.Empty(t, botRightsProblems("group", tgbotapi.ChatMember{Status: "member"}))
+}
+
+func TestBuildPauseNotice(t *testing.T) {
+	subs := []database.SubscriptionData{
+		{ID: 7, TwitchUsername: "streamer", ChannelName: "Новости"},
+		{ID: 9, TwitchUsername: "other", ChannelName: "Новости / тема 3"},
+	}
+	text, keyboard := buildPauseNotice("Новости", "бот не добавлен в группу", subs)
+	assert.Contains(t, text, "«Новости» приостановлены: бот не добавлен в группу.")
+	assert.Contains(t, text, "• other → Новости / тема 3")
+	if assert.Len(t, keyboard.InlineKeyboard, 2) {
+		assert.Equal(t, "sub_resume_9", *keyboard.InlineKeyboard[1][0].CallbackData)
+	}
+
+	// После нажатия кнопка пропадает, остальные остаются
+	keyboard = withoutButton(keyboard, "sub_resume_7")
+	if assert.Len(t, keyboard.InlineKeyboard, 1) {
+		assert.Equal(t, "sub_resume_9", *keyboard.InlineKeyboard[0][0].CallbackData)
+	}
+}
+
+func TestResumableAfterRightsUpdate(t *testing.T) {
+	subs := []database.SubscriptionData{
+		{ID: 1},
+		{ID: 2, Paused: true, PauseSource: database.PauseSourceChatMember, PauseReason: "бот не добавлен в группу"},
+		// Закрытую тему изменение прав бота не исправит
+		{ID: 3, Paused: true, PauseSource: database.PauseSourceSendError, PauseReason: "тема форума закрыта"},
+	}
+	assert.Equal(t, []int{2}, resumableAfterRightsUpdate(subs))
+}
+
+func TestParseTopicID(t *testing.T) {
+	for arg, want := range map[string]int{
+		"":                                 0,
+		"45":                               45,
+		"https://t.me/c/1234567890/45":     45,
+		"https://t.me/c/1234567890/45/678": 45,
+		"t.me/mygroup/45":                  45,
+		"https://t.me/mygroup/45/678/":     45,
+	} {
+		id, err := parseTopicID(arg)
+		if assert.NoError(t, err, arg) {
+			assert.Equal(t, want, id, arg)
+		}
+	}
+
+	for _, arg := range []string{"тема", "-3", "https://t.me/c/1234567890", "https://example.com/45"} {
+		_, err := parseTopicID(arg)
+		assert.Error(t, err, arg)
+	}
+}
+
+func TestIsAnonymousSender(t *testing.T) {
+	group := &tgbotapi.Chat{ID: -100, Type: "supergroup"}
+
+	assert.False(t, isAnonymousSender(&tgbotapi.Message{From: &tgbotapi.User{ID: 42}, Chat: group}))
+	assert.True(t, isAnonymousSender(&tgbotapi.Message{
+		From:       &tgbotapi.User{ID: groupAnonymousBotID, IsBot: true, UserName: "GroupAnonymousBot"},
+		SenderChat: group,
+		Chat:       group,
+	}))
+	// Сообщение из связанного канала
+	assert.True(t, isAnonymousSender(&tgbotapi.Message{
+		From:       &tgbotapi.User{ID: 136817688, IsBot: true, UserName: "Channel_Bot"},
+		SenderChat: &tgbotapi.Chat{ID: -200, Type: "channel"},
+		Chat:       group,
+	}))
+	assert.True(t, isAnonymousSender(&tgbotapi.Message{From: &tgbotapi.User{ID: groupAnonymousBotID}, Chat: group}))
+}
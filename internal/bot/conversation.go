@@ -0,0 +1,132 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"twitchannouncer/internal/database"
+)
+
+// ConversationState — шаг диалога, в котором находится пользователь
+type ConversationState string
+
+const (
+	StateIdle                   ConversationState = ""
+	StateAwaitingUsername       ConversationState = "awaiting_username"
+	StateConfirmingUsername     ConversationState = "confirming_username"
+	StateAwaitingChannel        ConversationState = "awaiting_channel"
+	StateAwaitingEmail          ConversationState = "awaiting_email"
+	StateAwaitingTemplate       ConversationState = "awaiting_template"
+	StateAwaitingDeleteUsername ConversationState = "awaiting_delete_username"
+)
+
+// stateTimeouts — сколько диалог может ждать ответа пользователя на каждом шаге
+var stateTimeouts = map[ConversationState]time.Duration{
+	StateAwaitingUsername:       15 * time.Minute,
+	StateConfirmingUsername:     15 * time.Minute,
+	StateAwaitingChannel:        15 * time.Minute,
+	StateAwaitingEmail:          15 * time.Minute,
+	StateAwaitingTemplate:       30 * time.Minute,
+	StateAwaitingDeleteUsername: 15 * time.Minute,
+}
+
+// ConversationKey — диалог ведётся отдельно для каждого пользователя в каждом чате
+type ConversationKey struct {
+	ChatID int64
+	UserID int64
+}
+
+// Conversation — состояние диалога и данные, собранные на предыдущих шагах
+type Conversation struct {
+	State            ConversationState `json:"state"`
+	TelegramUsername string            `json:"telegram_username,omitempty"`
+	TwitchUsername   string            `json:"twitch_username,omitempty"`
+	TwitchUserID     string            `json:"twitch_user_id,omitempty"`
+	TwitchName       string            `json:"twitch_name,omitempty"`
+	// TemplateSubscriptionID — подписка, шаблон которой редактируется
+	TemplateSubscriptionID int       `json:"template_subscription_id,omitempty"`
+	UpdatedAt              time.Time `json:"updated_at"`
+}
+
+// Expired сообщает, что пользователь не ответил за отведённое шагу время
+func (c Conversation) Expired(now time.Time) bool {
+	timeout, ok := stateTimeouts[c.State]
+	return ok && now.Sub(c.UpdatedAt) > timeout
+}
+
+// ConversationStore хранит диалоги пользователей. Get возвращает пустой
+// диалог в состоянии StateIdle, если сохранённого нет.
+type ConversationStore interface {
+	Get(ctx context.Context, key ConversationKey) (Conversation, error)
+	Set(ctx context.Context, key ConversationKey, conv Conversation) error
+	Delete(ctx context.Context, key ConversationKey) error
+}
+
+type memoryConversationStore struct {
+	mu            sync.Mutex
+	conversations map[ConversationKey]Conversation
+}
+
+func NewMemoryConversationStore() ConversationStore {
+	return &memoryConversationStore{
+		conversations: make(map[ConversationKey]Conversation),
+	}
+}
+
+func (s *memoryConversationStore) Get(ctx context.Context, key ConversationKey) (Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conversations[key], nil
+}
+
+func (s *memoryConversationStore) Set(ctx context.Context, key ConversationKey, conv Conversation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conv.UpdatedAt = time.Now()
+	s.conversations[key] = conv
+	return nil
+}
+
+func (s *memoryConversationStore) Delete(ctx context.Context, key ConversationKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conversations, key)
+	return nil
+}
+
+// postgresConversationStore переживает перезапуск бота посреди диалога
+type postgresConversationStore struct {
+	db *database.DB
+}
+
+func NewPostgresConversationStore(db *database.DB) ConversationStore {
+	return &postgresConversationStore{db: db}
+}
+
+func (s *postgresConversationStore) Get(ctx context.Context, key ConversationKey) (Conversation, error) {
+	var conv Conversation
+	data, err := s.db.GetConversation(ctx, key.ChatID, key.UserID)
+	if err != nil || data == nil {
+		return conv, err
+	}
+	if err := json.Unmarshal(data, &conv); err != nil {
+		return Conversation{}, fmt.Errorf("ошибка разбора диалога: %w", err)
+	}
+	return conv, nil
+}
+
+func (s *postgresConversationStore) Set(ctx context.Context, key ConversationKey, conv Conversation) error {
+	conv.UpdatedAt = time.Now()
+	data, err := json.Marshal(conv)
+	if err != nil {
+		return err
+	}
+	return s.db.SaveConversation(ctx, key.ChatID, key.UserID, string(conv.State), data)
+}
+
+func (s *postgresConversationStore) Delete(ctx context.Context, key ConversationKey) error {
+	return s.db.DeleteConversation(ctx, key.ChatID, key.UserID)
+}
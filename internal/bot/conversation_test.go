@@ -0,0 +1,77 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryConversationStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryConversationStore()
+	key := ConversationKey{ChatID: -100, UserID: 42}
+
+	conv, err := store.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, StateIdle, conv.State, "без сохранённого диалога возвращается пустой")
+
+	before := time.Now()
+	require.NoError(t, store.Set(ctx, key, Conversation{State: StateAwaitingChannel, TwitchUsername: "streamer"}))
+
+	conv, err = store.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, StateAwaitingChannel, conv.State)
+	assert.Equal(t, "streamer", conv.TwitchUsername)
+	assert.False(t, conv.UpdatedAt.Before(before), "Set обновляет время последнего шага")
+
+	// Диалоги разных пользователей в одном чате не пересекаются
+	other, err := store.Get(ctx, ConversationKey{ChatID: -100, UserID: 43})
+	require.NoError(t, err)
+	assert.Equal(t, StateIdle, other.State)
+
+	require.NoError(t, store.Delete(ctx, key))
+	conv, err = store.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, Conversation{}, conv)
+}
+
+func TestConversationExpired(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		state   ConversationState
+		age     time.Duration
+		expired bool
+	}{
+		{"ожидание ника в срок", StateAwaitingUsername, 14 * time.Minute, false},
+		{"ожидание ника истекло", StateAwaitingUsername, 16 * time.Minute, true},
+		{"шаблону даётся больше времени", StateAwaitingTemplate, 20 * time.Minute, false},
+		{"шаблон истёк", StateAwaitingTemplate, 31 * time.Minute, true},
+		{"удаление истекло", StateAwaitingDeleteUsername, 16 * time.Minute, true},
+		{"пустой диалог не истекает", StateIdle, 24 * time.Hour, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := Conversation{State: tt.state, UpdatedAt: now.Add(-tt.age)}
+			assert.Equal(t, tt.expired, conv.Expired(now))
+		})
+	}
+}
+
+func TestMemoryConversationStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryConversationStore()
+	key := ConversationKey{ChatID: 1, UserID: 1}
+
+	require.NoError(t, store.Set(ctx, key, Conversation{State: StateAwaitingEmail}))
+	conv, err := store.Get(ctx, key)
+	require.NoError(t, err)
+
+	assert.False(t, conv.Expired(time.Now()))
+	assert.True(t, conv.Expired(time.Now().Add(stateTimeouts[StateAwaitingEmail]+time.Second)))
+}
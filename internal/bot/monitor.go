@@ -2,41 +2,102 @@ package bot
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
+	"slices"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"twitchannouncer/internal/config"
 	"twitchannouncer/internal/database"
+	"twitchannouncer/internal/twitch"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+const (
+	// Максимальное количество user_id в одном запросе к helix/streams
+	helixMaxIDs = twitch.MaxIDsPerRequest
+
+	eventRetryAttempts = 6
+	eventRetryDelay    = 10 * time.Second
+	// Сколько опрос не должен противоречить событию EventSub
+	eventGracePeriod = 2 * time.Minute
+	// Минимальный интервал между изменениями одного оповещения
+	liveUpdateInterval = 2 * time.Minute
+	// Как часто проверять через helix/users, не сменили ли стримеры логин
+	renameCheckInterval = time.Hour
+	// Сколько подписок обновлять одновременно
+	maxParallelUpdates = 32
+)
+
 type Monitor struct {
-	bot *tgbotapi.BotAPI
-	db  *database.DB
-	cfg config.Config
+	queue  *SendQueue
+	db     *database.DB
+	cfg    config.Config
+	twitch twitch.Client
+
+	// subLocks не даёт опросу и EventSub одновременно отправить оповещение
+	// одной подписки. Общий mu берётся только на время поиска блокировки,
+	// поэтому разные подписки обновляются и ждут очередь отправки параллельно.
+	mu       sync.Mutex
+	subLocks map[int]*sync.Mutex
+
+	// events хранит последние события EventSub по ID стримера
+	eventsMu sync.Mutex
+	events   map[string]streamEvent
+
+	lastRenameCheck time.Time
+
+	// wg учитывает цикл опроса и обработку событий EventSub, чтобы при
+	// остановке дождаться уже начатых оповещений
+	wg       sync.WaitGroup
+	lifeMu   sync.Mutex
+	stopping bool
+	done     <-chan struct{}
+}
+
+type streamEvent struct {
+	live bool
+	at   time.Time
 }
 
 type StreamInfo struct {
-	Title       string
-	ViewerCount int
-	GameName    string
+	ID           string
+	UserID       string
+	UserLogin    string
+	UserName     string
+	Title        string
+	ViewerCount  int
+	GameName     string
+	ThumbnailURL string
+	Tags         []string
+	StartedAt    time.Time
 }
 
-func NewMonitor(bot *tgbotapi.BotAPI, db *database.DB, cfg config.Config) *Monitor {
+func NewMonitor(queue *SendQueue, db *database.DB, cfg config.Config, twitchClient twitch.Client) *Monitor {
 	return &Monitor{
-		bot: bot,
-		db:  db,
-		cfg: cfg,
+		queue:    queue,
+		db:       db,
+		cfg:      cfg,
+		twitch:   twitchClient,
+		events:   make(map[string]streamEvent),
+		subLocks: make(map[int]*sync.Mutex),
 	}
 }
 
 func (m *Monitor) Start(ctx context.Context, duration time.Duration) {
+	m.lifeMu.Lock()
+	m.done = ctx.Done()
+	m.lifeMu.Unlock()
+
+	if !m.track() {
+		return
+	}
 	go func() {
+		defer m.wg.Done()
 		ticker := time.NewTicker(duration)
 		defer ticker.Stop()
 
@@ -52,128 +113,713 @@ func (m *Monitor) Start(ctx context.Context, duration time.Duration) {
 }
 
 func (m *Monitor) Monitoring() {
+	m.resolveTwitchUserIDs()
+
+	getUserIDs := m.db.GetAllTwitchUserIDs
+	if !m.cfg.PollingActive() {
+		// О начале и конце стримов сообщает EventSub, опрашиваем только
+		// идущие стримы, чтобы обновлять их оповещения
+		getUserIDs = m.db.GetLiveTwitchUserIDs
+	}
+
+	userIDs, err := getUserIDs()
+	if err != nil {
+		log.Printf("Ошибка при получении твич-юзеров: %v", err)
+		return
+	}
+
+	live, checked := m.fetchLiveStreams(userIDs)
+
 	subs, err := m.db.GetAllSubscriptions()
 	if err != nil {
 		log.Println(err)
 	}
 
+	names := make([]twitch.User, 0, len(live))
+	for _, info := range live {
+		names = append(names, twitch.User{ID: info.UserID, Login: info.UserLogin, DisplayName: info.UserName})
+	}
+	if time.Since(m.lastRenameCheck) > renameCheckInterval {
+		m.lastRenameCheck = time.Now()
+		names = append(names, m.fetchUsers(subs)...)
+	}
+	if m.applyRenames(subs, names) {
+		// Перечитываем подписки, чтобы оповещения ушли с новым логином
+		if subs, err = m.db.GetAllSubscriptions(); err != nil {
+			log.Println(err)
+		}
+	}
+
+	var updates []subscriptionUpdate
 	for _, sub := range subs {
-		isLive, info := m.checkStreamStatus(sub.TwitchUsername)
+		// Если статус стримера получить не удалось, не трогаем его подписки,
+		// иначе при сбое Twitch API удалятся все активные оповещения
+		if !checked[sub.TwitchUserID] {
+			continue
+		}
+		info, isLive := live[sub.TwitchUserID]
+
+		// Helix отстаёт от EventSub: не откатываем только что пришедшее событие
+		if m.recentEvent(sub.TwitchUserID, isLive) {
+			continue
+		}
+
+		updates = append(updates, subscriptionUpdate{sub: sub, isLive: isLive, info: info})
+	}
+	m.updateSubscriptions(updates)
+}
+
+// resolveTwitchUserIDs находит ID стримеров для подписок, созданных по логину
+func (m *Monitor) resolveTwitchUserIDs() {
+	usernames, err := m.db.GetUnresolvedTwitchUsernames()
+	if err != nil {
+		log.Printf("Ошибка при получении твич-юзеров: %v", err)
+		return
+	}
+	if len(usernames) == 0 {
+		return
+	}
+
+	users, err := m.twitch.GetUsers(context.Background(), twitch.UsersQuery{Logins: usernames})
+	if err != nil {
+		log.Printf("Ошибка запроса к Twitch API: %v", err)
+		return
+	}
+	for _, user := range users {
+		if err := m.db.SetTwitchUserID(strings.ToLower(user.Login), user.ID, user.DisplayName); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// fetchUsers запрашивает актуальные логины стримеров подписок. Логин в
+// helix/streams меняется сразу, а офлайн-стримеров приходится проверять
+// через helix/users.
+func (m *Monitor) fetchUsers(subs []database.SubscriptionData) []twitch.User {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, sub := range subs {
+		if sub.TwitchUserID != "" && !seen[sub.TwitchUserID] {
+			seen[sub.TwitchUserID] = true
+			ids = append(ids, sub.TwitchUserID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	users, err := m.twitch.GetUsers(context.Background(), twitch.UsersQuery{IDs: ids})
+	if err != nil {
+		log.Printf("Ошибка запроса к Twitch API: %v", err)
+		return nil
+	}
+	return users
+}
+
+// applyRenames сравнивает имена стримеров из Twitch с подписками и
+// возвращает true, если какие-то подписки пришлось обновить
+func (m *Monitor) applyRenames(subs []database.SubscriptionData, users []twitch.User) bool {
+	known := make(map[string]database.SubscriptionData, len(subs))
+	for _, sub := range subs {
+		known[sub.TwitchUserID] = sub
+	}
+
+	changed := false
+	for _, user := range users {
+		sub, ok := known[user.ID]
+		if !ok || (sub.TwitchUsername == strings.ToLower(user.Login) && sub.TwitchDisplayName == user.DisplayName) {
+			continue
+		}
+		m.checkRename(user.ID, user.Login, user.DisplayName)
+		// Не проверяем одного стримера дважды за проход
+		delete(known, user.ID)
+		changed = true
+	}
+	return changed
+}
 
-		streamData, err := m.db.GetStreamData(sub.TwitchUsername)
+// checkRename обновляет подписки, если стример сменил логин или отображаемое
+// имя, и сообщает владельцам подписок о смене логина
+func (m *Monitor) checkRename(twitchUserID, login, displayName string) {
+	if twitchUserID == "" || login == "" {
+		return
+	}
+	login = strings.ToLower(login)
+
+	renamed, err := m.db.RenameTwitchUser(twitchUserID, login, displayName)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	notified := make(map[int64]bool)
+	for _, sub := range renamed {
+		log.Printf("Стример %s сменил ник на %s (подписка %d)", sub.TwitchUsername, login, sub.ID)
+		if notified[sub.UserID] {
+			continue
+		}
+		notified[sub.UserID] = true
+
+		text := fmt.Sprintf("ℹ️ Стример %s сменил ник на %s. Подписки обновлены, оповещения продолжат приходить.", sub.TwitchUsername, login)
+		if err := m.queue.Notify(sub.UserID, text); err != nil {
+			log.Printf("Не удалось уведомить %d о смене ника стримера: %v", sub.UserID, err)
+		}
+	}
+}
+
+// track регистрирует новую задачу монитора; после Wait новые задачи не запускаются
+func (m *Monitor) track() bool {
+	m.lifeMu.Lock()
+	defer m.lifeMu.Unlock()
+	if m.stopping {
+		return false
+	}
+	m.wg.Add(1)
+	return true
+}
+
+// Wait дожидается текущей проверки и начатых оповещений. Вызывается после
+// отмены контекста, переданного в Start.
+func (m *Monitor) Wait() {
+	m.lifeMu.Lock()
+	m.stopping = true
+	m.lifeMu.Unlock()
+	m.wg.Wait()
+}
+
+// sleep ждёт d и возвращает false, если монитор останавливается
+func (m *Monitor) sleep(d time.Duration) bool {
+	m.lifeMu.Lock()
+	done := m.done
+	m.lifeMu.Unlock()
+
+	select {
+	case <-time.After(d):
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// StreamOnline вызывается EventSub при событии stream.online
+func (m *Monitor) StreamOnline(twitchUserID string) {
+	if !m.track() {
+		return
+	}
+	defer m.wg.Done()
+	m.rememberEvent(twitchUserID, true)
+
+	// stream.online приходит раньше, чем стрим появляется в helix/streams,
+	// поэтому ждём, пока Twitch отдаст название и игру
+	var info StreamInfo
+	found := false
+	for attempt := 0; attempt < eventRetryAttempts && !found; attempt++ {
+		if attempt > 0 && !m.sleep(eventRetryDelay) {
+			return
+		}
+		streams, err := m.getStreams([]string{twitchUserID})
 		if err != nil {
-			log.Printf("Ошибка при получении твич-юзеров: %v", err)
+			log.Printf("Ошибка запроса к Twitch API: %v", err)
+			continue
+		}
+		info, found = streams[twitchUserID]
+	}
+	if !found {
+		log.Printf("Стрим %s не появился в helix/streams, оповещение отложено до опроса", twitchUserID)
+		return
+	}
+
+	m.checkRename(info.UserID, info.UserLogin, info.UserName)
+	m.updateUserSubscriptions(twitchUserID, true, info)
+}
+
+// StreamOffline вызывается EventSub при событии stream.offline
+func (m *Monitor) StreamOffline(twitchUserID string) {
+	if !m.track() {
+		return
+	}
+	defer m.wg.Done()
+	m.rememberEvent(twitchUserID, false)
+	m.updateUserSubscriptions(twitchUserID, false, StreamInfo{})
+}
+
+func (m *Monitor) updateUserSubscriptions(twitchUserID string, isLive bool, info StreamInfo) {
+	subs, err := m.db.GetSubscriptionsByTwitchUserID(twitchUserID)
+	if err != nil {
+		log.Printf("Ошибка при получении подписок %s: %v", twitchUserID, err)
+		return
+	}
+	updates := make([]subscriptionUpdate, 0, len(subs))
+	for _, sub := range subs {
+		updates = append(updates, subscriptionUpdate{sub: sub, isLive: isLive, info: info})
+	}
+	m.updateSubscriptions(updates)
+}
+
+type subscriptionUpdate struct {
+	sub    database.SubscriptionData
+	isLive bool
+	info   StreamInfo
+}
+
+// updateSubscriptions обновляет подписки параллельно: пока одна ждёт
+// retry_after своего чата, оповещения в другие чаты уходят, а очередь
+// отправки выбирает между ними по приоритету
+func (m *Monitor) updateSubscriptions(updates []subscriptionUpdate) {
+	forEachParallel(updates, maxParallelUpdates, func(u subscriptionUpdate) {
+		m.updateSubscription(u.sub, u.isLive, u.info)
+	})
+}
+
+// forEachParallel вызывает fn для каждого элемента, не больше limit одновременно
+func forEachParallel[T any](items []T, limit int, fn func(T)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, limit)
+	for _, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(item)
+		}()
+	}
+	wg.Wait()
+}
+
+// lockSubscription блокирует обновление подписки id и возвращает разблокировку
+func (m *Monitor) lockSubscription(id int) func() {
+	m.mu.Lock()
+	if m.subLocks == nil {
+		m.subLocks = make(map[int]*sync.Mutex)
+	}
+	lock, ok := m.subLocks[id]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.subLocks[id] = lock
+	}
+	m.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+func (m *Monitor) rememberEvent(twitchUserID string, live bool) {
+	m.eventsMu.Lock()
+	defer m.eventsMu.Unlock()
+	m.events[twitchUserID] = streamEvent{live: live, at: time.Now()}
+}
+
+// recentEvent сообщает, что недавно пришло событие EventSub, противоречащее
+// статусу из helix/streams
+func (m *Monitor) recentEvent(twitchUserID string, isLive bool) bool {
+	m.eventsMu.Lock()
+	defer m.eventsMu.Unlock()
+	event, ok := m.events[twitchUserID]
+	if !ok {
+		return false
+	}
+	if time.Since(event.at) > eventGracePeriod {
+		delete(m.events, twitchUserID)
+		return false
+	}
+	return event.live != isLive
+}
+
+// updateSubscription отправляет или удаляет оповещение подписки в зависимости
+// от статуса стрима. Вызывается и опросом, и EventSub.
+func (m *Monitor) updateSubscription(sub database.SubscriptionData, isLive bool, info StreamInfo) {
+	// Бот не может писать в чат, пока владелец не вернёт права
+	if sub.Paused {
+		return
+	}
+
+	defer m.lockSubscription(sub.ID)()
+
+	announcement, err := m.db.GetStreamAnnouncement(sub.ID, sub.ChannelID)
+	if err != nil {
+		log.Printf("Ошибка при получении данных стрима: %v", err)
+		return
+	}
+
+	if isLive && (!announcement.Checked || !announcement.Live) {
+		isPro, _, err := m.db.IsUserPro(sub.UserID)
+		if err != nil {
+			log.Println(err)
+		}
+
+		sentMsg, text, isPhoto, err := m.sendAnnouncement(sub, info, isPro)
+		if err != nil {
+			log.Printf("Ошибка отправки сообщения: %v", err)
+			m.handleSendError(sub, err)
 			return
 		}
+		log.Printf("Сообщение успешно отправлено. %s", text)
+		m.handleSendSuccess(sub)
 
-		if isLive && (!streamData.Checked || !streamData.Live) {
-			isPro, _, err := m.db.IsUserPro(sub.UserID)
-			if err != nil {
-				log.Println(err)
-			}
-			if isPro {
-				text := fmt.Sprintf(
-					"🔴 *%s* начал стрим!\n📝 *Название:* %s\n🎮 *Игра:* %s\n👉 https://twitch.tv/%s",
-					escapeMarkdown(sub.TwitchUsername),
-					escapeMarkdown(info.Title),
-					escapeMarkdown(info.GameName),
-					escapeMarkdown(sub.TwitchUsername),
-				)
-
-				msg := tgbotapi.NewMessage(sub.ChannelID, text)
-				msg.ParseMode = "MarkdownV2"
-
-				sentMsg, err := m.bot.Send(msg)
-				if err != nil {
-					log.Printf("Ошибка отправки сообщения: %v", err)
-					continue
-				}
-				log.Printf("Сообщение успешно отправлено. %s", sentMsg.Text)
-
-				err = m.db.UpdateStreamStatus(sub.TwitchUsername, true, true, sentMsg.MessageID)
-				if err != nil {
-					log.Printf("Ошибка обновления статуса стрима: %v", err)
-				}
-			} else {
-				text := fmt.Sprintf(
-					"🔴 *%s* начал стрим!\n📝 *Название:* %s\n🎮 *Игра:* %s\n👉 https://twitch.tv/%s\n\nОтправлено с помощью https://t.me/Twitchmanannouncer_bot",
-					sub.TwitchUsername,
-					info.Title,
-					info.GameName,
-					sub.TwitchUsername,
-				)
-
-				msg := tgbotapi.NewMessage(sub.ChannelID, escapeMarkdown(text))
-				msg.ParseMode = "MarkdownV2"
-
-				sentMsg, err := m.bot.Send(msg)
-				if err != nil {
-					log.Printf("Ошибка отправки сообщения: %v", err)
-					continue
-				}
-				log.Printf("Сообщение успешно отправлено. %s", sentMsg.Text)
-
-				err = m.db.UpdateStreamStatus(sub.TwitchUsername, true, true, sentMsg.MessageID)
-				if err != nil {
-					log.Printf("Ошибка обновления статуса стрима: %v", err)
-				}
-			}
+		startedAt := info.StartedAt
+		if startedAt.IsZero() {
+			startedAt = time.Now()
 		}
+		announcement = &database.StreamAnnouncement{
+			SubscriptionID: sub.ID,
+			ChannelID:      sub.ChannelID,
+			MessageID:      sentMsg.MessageID,
+			Live:           true,
+			Checked:        true,
+			IsPhoto:        isPhoto,
+			LastText:       text,
+			EditedAt:       time.Now(),
+			StartedAt:      startedAt,
+		}
+		trackStreamStats(announcement, info)
+
+		if err := m.db.SaveStreamAnnouncement(*announcement); err != nil {
+			log.Printf("Ошибка обновления статуса стрима: %v", err)
+		}
+		return
+	}
 
-		if !isLive && streamData.Checked && streamData.Live {
-			del := tgbotapi.NewDeleteMessage(sub.ChannelID, sub.LatestMessageID)
-			_, err := m.bot.Request(del)
-			if err != nil {
-				log.Printf("Ошибка при удалении сообщения: %v", err)
+	if isLive && announcement.Live {
+		changed := trackStreamStats(announcement, info)
+		if sub.LiveUpdates && m.refreshAnnouncement(sub, announcement, info) {
+			changed = true
+		}
+		if changed {
+			if err := m.db.SaveStreamAnnouncement(*announcement); err != nil {
+				log.Printf("Ошибка обновления статуса стрима: %v", err)
 			}
-			err = m.db.UpdateStreamStatus(sub.TwitchUsername, false, false, 0)
+		}
+		return
+	}
+
+	if !isLive && announcement.Checked && announcement.Live {
+		m.finishAnnouncement(sub, announcement)
+		err = m.db.DeleteStreamAnnouncement(sub.ID, sub.ChannelID)
+		if err != nil {
+			log.Printf("Ошибка обновления статуса стрима: %v", err)
+		}
+	}
+}
+
+// trackStreamStats запоминает данные для итогов стрима и сообщает, изменились ли они
+func trackStreamStats(announcement *database.StreamAnnouncement, info StreamInfo) bool {
+	changed := false
+	if info.ID != "" && announcement.StreamID != info.ID {
+		announcement.StreamID = info.ID
+		announcement.TwitchUserID = info.UserID
+		changed = true
+	}
+	if info.Title != "" && announcement.Title != info.Title {
+		announcement.Title = info.Title
+		changed = true
+	}
+	if info.ViewerCount > announcement.PeakViewers {
+		announcement.PeakViewers = info.ViewerCount
+		changed = true
+	}
+	if info.GameName != "" && !slices.Contains(announcement.Games, info.GameName) {
+		announcement.Games = append(announcement.Games, info.GameName)
+		changed = true
+	}
+	return changed
+}
+
+// finishAnnouncement обрабатывает оповещение после окончания стрима
+// согласно настройке подписки
+func (m *Monitor) finishAnnouncement(sub database.SubscriptionData, announcement *database.StreamAnnouncement) {
+	var err error
+	switch sub.OfflineMode {
+	case database.OfflineModeKeep:
+		return
+
+	case database.OfflineModeSummary:
+		summary := m.streamSummary(sub, announcement)
+		var edit tgbotapi.Chattable
+		if announcement.IsPhoto {
+			caption := tgbotapi.NewEditMessageCaption(sub.ChannelID, announcement.MessageID, summary)
+			caption.ParseMode = "MarkdownV2"
+			edit = caption
+		} else {
+			text := tgbotapi.NewEditMessageText(sub.ChannelID, announcement.MessageID, summary)
+			text.ParseMode = "MarkdownV2"
+			edit = text
+		}
+		_, err = m.queue.send(sub.ChannelID, priorityEdit, edit)
+
+	case database.OfflineModeSeparate:
+		_, err = m.queue.sendText(sub.ChannelID, sub.MessageThreadID, priorityEdit, m.streamSummary(sub, announcement), "MarkdownV2")
+
+	default:
+		err = m.queue.request(sub.ChannelID, priorityEdit, tgbotapi.NewDeleteMessage(sub.ChannelID, announcement.MessageID))
+	}
+
+	if err != nil {
+		log.Printf("Ошибка при завершении оповещения подписки %d: %v", sub.ID, err)
+		m.handleSendError(sub, err)
+		return
+	}
+	m.handleSendSuccess(sub)
+}
 
+// streamSummary собирает итоги стрима: длительность, пик зрителей, игры и запись
+func (m *Monitor) streamSummary(sub database.SubscriptionData, announcement *database.StreamAnnouncement) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "⚫ *%s* завершил стрим\n", escapeMarkdown(sub.TwitchUsername))
+	if announcement.Title != "" {
+		fmt.Fprintf(&text, "📝 *Название:* %s\n", escapeMarkdown(announcement.Title))
+	}
+	fmt.Fprintf(&text, "⏱ *Длительность:* %s\n", escapeMarkdown(formatDuration(time.Since(announcement.StartedAt))))
+	fmt.Fprintf(&text, "👥 *Пик зрителей:* %d\n", announcement.PeakViewers)
+	if len(announcement.Games) > 0 {
+		games := make([]string, 0, len(announcement.Games))
+		for _, game := range announcement.Games {
+			games = append(games, escapeMarkdown(game))
 		}
+		fmt.Fprintf(&text, "🎮 *Игры:* %s\n", strings.Join(games, ", "))
 	}
+
+	if announcement.TwitchUserID != "" && announcement.StreamID != "" {
+		vodURL, err := m.getLatestVOD(announcement.TwitchUserID, announcement.StreamID)
+		if err != nil {
+			log.Printf("Ошибка получения записи стрима %s: %v", announcement.StreamID, err)
+		}
+		if vodURL != "" {
+			fmt.Fprintf(&text, "📼 *Запись:* %s\n", escapeMarkdown(vodURL))
+		}
+	}
+
+	return strings.TrimSuffix(text.String(), "\n")
 }
 
-func (m *Monitor) checkStreamStatus(username string) (bool, StreamInfo) {
-	url := fmt.Sprintf("https://api.twitch.tv/helix/streams?user_login=%s", username)
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("Client-ID", m.cfg.TwitchClientID)
-	req.Header.Set("Authorization", "Bearer "+m.cfg.TwitchOAuthToken)
+// refreshAnnouncement редактирует отправленное оповещение, если данные
+// стрима изменились и с прошлого изменения прошло liveUpdateInterval.
+// Возвращает true, если оповещение изменено.
+func (m *Monitor) refreshAnnouncement(sub database.SubscriptionData, announcement *database.StreamAnnouncement, info StreamInfo) bool {
+	if time.Since(announcement.EditedAt) < liveUpdateInterval {
+		return false
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	isPro, _, err := m.db.IsUserPro(sub.UserID)
 	if err != nil {
-		log.Printf("Ошибка запроса к Twitch API: %v", err)
-		return false, StreamInfo{}
+		log.Println(err)
+	}
+
+	text := m.announcementText(sub, info, sub.Template, isPro)
+	if text == announcement.LastText {
+		return false
+	}
+
+	var edit tgbotapi.Chattable
+	if announcement.IsPhoto {
+		if caption := refreshCaption(text, announcement.LastText); caption != text {
+			log.Printf("Оповещение подписки %d длиннее %d символов, обновляем только превью", sub.ID, maxCaptionLength)
+			text = caption
+		}
+		media := tgbotapi.NewInputMediaPhoto(tgbotapi.FileURL(thumbnailURL(info.ThumbnailURL)))
+		media.Caption = text
+		media.ParseMode = "MarkdownV2"
+		edit = tgbotapi.EditMessageMediaConfig{
+			BaseEdit: tgbotapi.BaseEdit{ChatID: sub.ChannelID, MessageID: announcement.MessageID},
+			Media:    media,
+		}
+	} else {
+		textEdit := tgbotapi.NewEditMessageText(sub.ChannelID, announcement.MessageID, text)
+		textEdit.ParseMode = "MarkdownV2"
+		edit = textEdit
+	}
+	if _, err := m.queue.send(sub.ChannelID, priorityEdit, edit); err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		log.Printf("Ошибка обновления оповещения подписки %d: %v", sub.ID, err)
+		m.handleSendError(sub, err)
+		return false
+	}
+	m.handleSendSuccess(sub)
+
+	announcement.LastText = text
+	announcement.EditedAt = time.Now()
+	return true
+}
+
+// refreshCaption — подпись для изменения оповещения с фото. Длинную подпись
+// Telegram отклонит, поэтому в этом случае остаётся прежняя.
+func refreshCaption(text, previous string) string {
+	if utf8.RuneCountInString(text) > maxCaptionLength {
+		return previous
+	}
+	return text
+}
+
+// sendAnnouncement отправляет оповещение фото с превью стрима или текстом.
+// Если фото отправить не удалось, оповещение уходит текстом, а если не
+// подошёл пользовательский шаблон — текстом по шаблону по умолчанию.
+func (m *Monitor) sendAnnouncement(sub database.SubscriptionData, info StreamInfo, isPro bool) (tgbotapi.Message, string, bool, error) {
+	text := m.announcementText(sub, info, sub.Template, isPro)
+
+	if sub.PhotoMode && info.ThumbnailURL != "" && utf8.RuneCountInString(text) <= maxCaptionLength {
+		sentMsg, err := m.queue.sendPhotoURL(sub.ChannelID, sub.MessageThreadID, priorityAnnouncement, thumbnailURL(info.ThumbnailURL), text, "MarkdownV2")
+		if err == nil {
+			return sentMsg, text, true, nil
+		}
+		log.Printf("Ошибка отправки превью подписки %d, отправляем текст: %v", sub.ID, err)
+	}
+
+	sentMsg, err := m.queue.sendText(sub.ChannelID, sub.MessageThreadID, priorityAnnouncement, text, "MarkdownV2")
+	if err != nil && sub.Template != "" {
+		// Пользовательский шаблон не должен мешать оповещению
+		log.Printf("Ошибка отправки сообщения по шаблону подписки %d: %v", sub.ID, err)
+		text = m.announcementText(sub, info, "", isPro)
+		sentMsg, err = m.queue.sendText(sub.ChannelID, sub.MessageThreadID, priorityAnnouncement, text, "MarkdownV2")
+	}
+	return sentMsg, text, false, err
+}
+
+// handleSendError учитывает ошибку отправки в чат подписки. После постоянной
+// ошибки подписка ставится на паузу, а владелец получает сообщение с причиной.
+func (m *Monitor) handleSendError(sub database.SubscriptionData, sendErr error) {
+	ctx := context.Background()
+	failure := classifySendError(sendErr)
+	if !failure.Permanent {
+		if err := m.db.RecordSubscriptionFailure(ctx, sub.ID, sendErr.Error()); err != nil {
+			log.Print(err)
+		}
+		return
+	}
+
+	paused, err := m.db.PauseSubscription(ctx, sub.ID, failure.Reason, sendErr.Error())
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if !paused {
+		return
+	}
+	log.Printf("Подписка %d приостановлена после ошибки отправки: %v", sub.ID, sendErr)
+
+	text, keyboard := buildPauseNotice(sub.ChannelName, failure.Reason, []database.SubscriptionData{sub})
+	msg := tgbotapi.NewMessage(sub.UserID, text)
+	msg.ReplyMarkup = keyboard
+	if _, err := m.queue.send(sub.UserID, priorityMessage, msg); err != nil {
+		log.Printf("Не удалось сообщить пользователю %d о паузе подписки: %v", sub.UserID, err)
+	}
+}
+
+// handleSendSuccess сбрасывает счётчик ошибок: он считает только ошибки подряд
+func (m *Monitor) handleSendSuccess(sub database.SubscriptionData) {
+	if sub.FailureCount == 0 {
+		return
+	}
+	if err := m.db.ResetSubscriptionFailures(context.Background(), sub.ID); err != nil {
+		log.Print(err)
 	}
-	defer resp.Body.Close()
+}
+
+// thumbnailURL подставляет размер в шаблон превью Twitch и добавляет параметр,
+// чтобы Telegram не взял старую картинку из кэша
+func thumbnailURL(raw string) string {
+	thumbnail := strings.NewReplacer("{width}", "1280", "{height}", "720").Replace(raw)
+	return fmt.Sprintf("%s?t=%d", thumbnail, time.Now().Unix())
+}
+
+// announcementText собирает текст оповещения по шаблону. Если шаблон не
+// удалось применить, используется шаблон по умолчанию.
+func (m *Monitor) announcementText(sub database.SubscriptionData, info StreamInfo, tmpl string, isPro bool) string {
+	data := newAnnouncementData(sub.TwitchUsername, info)
+	text, err := renderAnnouncement(tmpl, data)
+	if err != nil {
+		log.Printf("Ошибка шаблона подписки %d: %v", sub.ID, err)
+		text, _ = renderAnnouncement("", data)
+	}
+
+	if sub.LiveUpdates {
+		text += fmt.Sprintf("\n\n👥 %d · ⏱ %s", data.Viewers, data.Uptime)
+	}
+
+	if !isPro && m.cfg.BotLink != "" {
+		text += escapeMarkdown("\n\nОтправлено с помощью " + m.cfg.BotLink)
+	}
+	return text
+}
+
+// fetchLiveStreams запрашивает статус стримеров пачками по helixMaxIDs ID.
+// Возвращает активные стримы по ID стримера и множество ID, статус которых
+// удалось получить: при ошибке запроса ID пачки в него не попадают.
+func (m *Monitor) fetchLiveStreams(userIDs []string) (map[string]StreamInfo, map[string]bool) {
+	live := make(map[string]StreamInfo)
+	checked := make(map[string]bool, len(userIDs))
+
+	for start := 0; start < len(userIDs); start += helixMaxIDs {
+		end := start + helixMaxIDs
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+		batch := userIDs[start:end]
 
-	var result struct {
-		Data []struct {
-			Type        string `json:"type"`
-			Title       string `json:"title"`
-			ViewerCount int    `json:"viewer_count"`
-			GameName    string `json:"game_name"`
-		} `json:"data"`
+		streams, err := m.getStreams(batch)
+		if err != nil {
+			log.Printf("Ошибка запроса к Twitch API: %v", err)
+			continue
+		}
+
+		for _, id := range batch {
+			checked[id] = true
+		}
+		for id, info := range streams {
+			live[id] = info
+		}
+	}
+
+	return live, checked
+}
+
+// getStreams возвращает идущие стримы по ID стримера
+func (m *Monitor) getStreams(userIDs []string) (map[string]StreamInfo, error) {
+	result, err := m.twitch.GetStreams(context.Background(), twitch.StreamsQuery{UserIDs: userIDs})
+	if err != nil {
+		return nil, err
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		log.Printf("Ошибка при декодировании ответа Twitch API: %v", err)
-		return false, StreamInfo{}
+	streams := make(map[string]StreamInfo, len(result))
+	for _, stream := range result {
+		streams[stream.UserID] = StreamInfo{
+			ID:           stream.ID,
+			UserID:       stream.UserID,
+			UserLogin:    stream.UserLogin,
+			UserName:     stream.UserName,
+			Title:        stream.Title,
+			ViewerCount:  stream.ViewerCount,
+			GameName:     stream.GameName,
+			ThumbnailURL: stream.ThumbnailURL,
+			Tags:         stream.Tags,
+			StartedAt:    stream.StartedAt,
+		}
 	}
+	return streams, nil
+}
 
-	if len(result.Data) == 0 {
-		return false, StreamInfo{}
+// getLatestVOD ищет запись стрима streamID среди последних архивов канала
+func (m *Monitor) getLatestVOD(userID, streamID string) (string, error) {
+	videos, err := m.twitch.GetVideos(context.Background(), twitch.VideosQuery{
+		UserID: userID,
+		Type:   "archive",
+		First:  5,
+	})
+	if err != nil {
+		return "", err
 	}
 
-	stream := result.Data[0]
-	return true, StreamInfo{
-		Title:       stream.Title,
-		ViewerCount: stream.ViewerCount,
-		GameName:    stream.GameName,
+	for _, video := range videos {
+		if video.StreamID == streamID {
+			return video.URL, nil
+		}
 	}
+	return "", nil
 }
 
 func escapeMarkdown(text string) string {
 	replacer := strings.NewReplacer(
+		`\`, `\\`,
 		`_`, `\_`,
+		`*`, `\*`,
 		`[`, `\[`,
 		`]`, `\]`,
 		`(`, `\(`,
@@ -0,0 +1,154 @@
+package bot
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"twitchannouncer/internal/database"
+	"twitchannouncer/internal/twitch"
+	"twitchannouncer/internal/twitch/twitchtest"
+)
+
+func TestFetchLiveStreams(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+
+	var userIDs []string
+	for i := 0; i < 120; i++ {
+		userIDs = append(userIDs, fmt.Sprint(1000+i))
+	}
+	server.SetStreams(
+		twitch.Stream{ID: "1", UserID: "1005", UserLogin: "streamer_5", UserName: "Streamer_5", Title: "Первый"},
+		twitch.Stream{ID: "2", UserID: "1110", UserLogin: "streamer_110", Title: "Второй"},
+	)
+
+	m := &Monitor{twitch: server.Client()}
+	live, checked := m.fetchLiveStreams(userIDs)
+
+	assert.Len(t, checked, 120)
+	assert.Len(t, live, 2)
+	assert.Equal(t, "Первый", live["1005"].Title)
+	assert.Equal(t, "streamer_110", live["1110"].UserLogin)
+}
+
+func TestFetchLiveStreamsSkipsFailedBatch(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.SetStreams(twitch.Stream{ID: "1", UserID: "1000", UserLogin: "streamer"})
+
+	m := &Monitor{twitch: server.Client()}
+	server.Close()
+
+	live, checked := m.fetchLiveStreams([]string{"1000"})
+	assert.Empty(t, live)
+	assert.False(t, checked["1000"], "при ошибке Twitch API статус стримера неизвестен")
+}
+
+func TestRefreshCaption(t *testing.T) {
+	assert.Equal(t, "новый", refreshCaption("новый", "старый"))
+
+	long := strings.Repeat("я", maxCaptionLength+1)
+	assert.Equal(t, "старый", refreshCaption(long, "старый"))
+	assert.Equal(t, long[:maxCaptionLength*2], refreshCaption(long[:maxCaptionLength*2], "старый"))
+}
+
+func TestStreamSummary(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.SetVideos(
+		twitch.Video{ID: "1", StreamID: "s-old", UserID: "1005", Type: "archive", URL: "https://www.twitch.tv/videos/1"},
+		twitch.Video{ID: "2", StreamID: "s-1", UserID: "1005", Type: "archive", URL: "https://www.twitch.tv/videos/2"},
+	)
+	m := &Monitor{twitch: server.Client()}
+	sub := database.SubscriptionData{TwitchUsername: "some_streamer"}
+
+	tests := []struct {
+		name         string
+		announcement database.StreamAnnouncement
+		want         string
+	}{
+		{
+			name: "полные итоги",
+			announcement: database.StreamAnnouncement{
+				StreamID:     "s-1",
+				TwitchUserID: "1005",
+				Title:        "Ранний доступ [день 2]!",
+				StartedAt:    time.Now().Add(-(2*time.Hour + 5*time.Minute + 30*time.Second)),
+				PeakViewers:  1234,
+				Games:        []string{"Half-Life 2", "S.T.A.L.K.E.R. 2"},
+			},
+			want: "⚫ *some\\_streamer* завершил стрим\n" +
+				"📝 *Название:* Ранний доступ \\[день 2\\]\\!\n" +
+				"⏱ *Длительность:* 2ч 05м\n" +
+				"👥 *Пик зрителей:* 1234\n" +
+				"🎮 *Игры:* Half\\-Life 2, S\\.T\\.A\\.L\\.K\\.E\\.R\\. 2\n" +
+				"📼 *Запись:* https://www\\.twitch\\.tv/videos/2",
+		},
+		{
+			name: "короткий стрим без названия и игр",
+			announcement: database.StreamAnnouncement{
+				StartedAt: time.Now().Add(-(45*time.Minute + 30*time.Second)),
+			},
+			want: "⚫ *some\\_streamer* завершил стрим\n" +
+				"⏱ *Длительность:* 45м\n" +
+				"👥 *Пик зрителей:* 0",
+		},
+		{
+			name: "запись ещё не появилась",
+			announcement: database.StreamAnnouncement{
+				StreamID:     "s-2",
+				TwitchUserID: "1005",
+				StartedAt:    time.Now().Add(-(time.Hour + 30*time.Second)),
+				PeakViewers:  10,
+			},
+			want: "⚫ *some\\_streamer* завершил стрим\n" +
+				"⏱ *Длительность:* 1ч 00м\n" +
+				"👥 *Пик зрителей:* 10",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, m.streamSummary(sub, &tt.announcement))
+		})
+	}
+}
+
+func TestThumbnailURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{
+			raw:  "https://static-cdn.jtvnw.net/previews-ttv/live_user_streamer-{width}x{height}.jpg",
+			want: "https://static-cdn.jtvnw.net/previews-ttv/live_user_streamer-1280x720.jpg",
+		},
+		{
+			raw:  "https://static-cdn.jtvnw.net/previews-ttv/live_user_streamer-640x360.jpg",
+			want: "https://static-cdn.jtvnw.net/previews-ttv/live_user_streamer-640x360.jpg",
+		},
+	}
+
+	for _, tt := range tests {
+		before := time.Now().Unix()
+		got := thumbnailURL(tt.raw)
+		after := time.Now().Unix()
+
+		base, query, ok := strings.Cut(got, "?")
+		assert.True(t, ok, "нет параметра против кэша: %s", got)
+		assert.Equal(t, tt.want, base)
+
+		values, err := url.ParseQuery(query)
+		assert.NoError(t, err)
+		var stamp int64
+		_, err = fmt.Sscan(values.Get("t"), &stamp)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, stamp, before)
+		assert.LessOrEqual(t, stamp, after)
+	}
+}
@@ -0,0 +1,332 @@
+package bot
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Лимиты Telegram: около 30 сообщений в секунду всего, не больше 20 в
+// минуту в одну группу или канал и примерно одно в секунду в личный чат.
+// https://core.telegram.org/bots/faq#my-bot-is-hitting-limits-how-do-i-avoid-this
+const (
+	globalRate  = 30
+	globalBurst = 30
+
+	groupRate  = 20.0 / 60
+	groupBurst = 3
+
+	privateRate  = 1
+	privateBurst = 1
+
+	// Сколько раз повторять отправку после ответа 429
+	maxSendAttempts = 5
+	// Пауза после 429, если Telegram не указал retry_after
+	defaultRetryAfter = time.Second
+	// Как часто очищать лимиты чатов, в которые давно ничего не отправлялось
+	queueIdleWait = time.Minute
+)
+
+var errQueueStopped = errors.New("очередь отправки остановлена")
+
+// priority — порядок отправки: сначала оповещения о начале стрима, затем
+// личные сообщения, затем изменения и удаление уже отправленных оповещений
+type priority int
+
+const (
+	priorityAnnouncement priority = iota
+	priorityMessage
+	priorityEdit
+)
+
+// SendQueue отправляет сообщения в Telegram с соблюдением общего лимита и
+// лимитов отдельных чатов. Сообщения с более высоким приоритетом уходят
+// первыми; после ответа 429 отправка в чат откладывается на retry_after и
+// повторяется.
+type SendQueue struct {
+	bot *tgbotapi.BotAPI
+
+	mu     sync.Mutex
+	jobs   []*sendJob
+	seq    uint64
+	global *tokenBucket
+	chats  map[int64]*tokenBucket
+	closed bool
+
+	wake    chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+type sendJob struct {
+	chatID   int64
+	priority priority
+	seq      uint64
+	attempts int
+	send     func() error
+	result   chan error
+}
+
+func NewSendQueue(bot *tgbotapi.BotAPI) *SendQueue {
+	return &SendQueue{
+		bot:     bot,
+		global:  newTokenBucket(globalRate, globalBurst, time.Now()),
+		chats:   make(map[int64]*tokenBucket),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+func (q *SendQueue) Start() {
+	go q.run()
+}
+
+// Stop останавливает очередь; неотправленные сообщения завершаются ошибкой
+func (q *SendQueue) Stop() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	q.mu.Unlock()
+
+	close(q.stop)
+	<-q.stopped
+
+	q.mu.Lock()
+	jobs := q.jobs
+	q.jobs = nil
+	q.mu.Unlock()
+	for _, job := range jobs {
+		job.result <- errQueueStopped
+	}
+}
+
+// do ставит отправку в очередь и ждёт её результата
+func (q *SendQueue) do(chatID int64, p priority, send func() error) error {
+	job := &sendJob{chatID: chatID, priority: p, send: send, result: make(chan error, 1)}
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return errQueueStopped
+	}
+	job.seq = q.seq
+	q.seq++
+	q.push(job)
+	q.mu.Unlock()
+
+	q.signal()
+	return <-job.result
+}
+
+// push вставляет задачу по приоритету, внутри приоритета — в порядке постановки
+func (q *SendQueue) push(job *sendJob) {
+	i, _ := slices.BinarySearchFunc(q.jobs, job, func(a, b *sendJob) int {
+		if a.priority != b.priority {
+			return int(a.priority) - int(b.priority)
+		}
+		switch {
+		case a.seq < b.seq:
+			return -1
+		case a.seq > b.seq:
+			return 1
+		}
+		return 0
+	})
+	q.jobs = slices.Insert(q.jobs, i, job)
+}
+
+func (q *SendQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *SendQueue) run() {
+	defer close(q.stopped)
+	for {
+		q.mu.Lock()
+		job, wait := q.next(time.Now())
+		q.mu.Unlock()
+
+		if job != nil {
+			go q.execute(job)
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-q.wake:
+		case <-timer.C:
+		case <-q.stop:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// next выбирает первую по приоритету задачу, которую лимиты разрешают
+// отправить сейчас. Если таких нет, возвращает, сколько ждать.
+func (q *SendQueue) next(now time.Time) (*sendJob, time.Duration) {
+	if len(q.jobs) == 0 {
+		q.prune(now)
+		return nil, queueIdleWait
+	}
+	if wait := q.global.delay(now); wait > 0 {
+		return nil, wait
+	}
+
+	wait := queueIdleWait
+	for i, job := range q.jobs {
+		bucket := q.chatBucket(job.chatID, now)
+		if d := bucket.delay(now); d > 0 {
+			// Чат упёрся в лимит — пропускаем его, не задерживая остальные чаты
+			wait = min(wait, d)
+			continue
+		}
+		bucket.take()
+		q.global.take()
+		q.jobs = slices.Delete(q.jobs, i, i+1)
+		return job, 0
+	}
+	return nil, wait
+}
+
+func (q *SendQueue) chatBucket(chatID int64, now time.Time) *tokenBucket {
+	bucket, ok := q.chats[chatID]
+	if !ok {
+		// У групп и каналов отрицательные ID
+		if chatID < 0 {
+			bucket = newTokenBucket(groupRate, groupBurst, now)
+		} else {
+			bucket = newTokenBucket(privateRate, privateBurst, now)
+		}
+		q.chats[chatID] = bucket
+	}
+	return bucket
+}
+
+// prune забывает лимиты чатов, которые полностью восстановились
+func (q *SendQueue) prune(now time.Time) {
+	for chatID, bucket := range q.chats {
+		if bucket.full(now) {
+			delete(q.chats, chatID)
+		}
+	}
+}
+
+func (q *SendQueue) execute(job *sendJob) {
+	err := job.send()
+
+	if wait, ok := retryAfter(err); ok && job.attempts+1 < maxSendAttempts {
+		q.mu.Lock()
+		if !q.closed {
+			now := time.Now()
+			job.attempts++
+			q.chatBucket(job.chatID, now).block(now.Add(wait))
+			// Если общий лимит израсходован, Telegram, скорее всего, ограничил
+			// бота целиком, и остальные чаты получат тот же ответ
+			if q.global.empty(now) {
+				q.global.block(now.Add(wait))
+			}
+			q.push(job)
+			q.mu.Unlock()
+			log.Printf("Telegram ограничил отправку в чат %d, повтор через %v", job.chatID, wait)
+			q.signal()
+			return
+		}
+		q.mu.Unlock()
+	}
+	job.result <- err
+}
+
+var retryAfterRegex = regexp.MustCompile(`retry after (\d+)`)
+
+// retryAfter возвращает, через сколько можно повторить запрос после ответа 429
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) {
+		return 0, false
+	}
+	if apiErr.RetryAfter > 0 {
+		return time.Duration(apiErr.RetryAfter) * time.Second, true
+	}
+	if apiErr.Code != http.StatusTooManyRequests {
+		return 0, false
+	}
+	if m := retryAfterRegex.FindStringSubmatch(apiErr.Message); m != nil {
+		if seconds, err := strconv.Atoi(m[1]); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return defaultRetryAfter, true
+}
+
+// tokenBucket — лимит rate сообщений в секунду с запасом burst
+type tokenBucket struct {
+	rate         float64
+	burst        float64
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+}
+
+func newTokenBucket(rate, burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// delay — через сколько можно отправить сообщение, 0 — можно сейчас
+func (b *tokenBucket) delay(now time.Time) time.Duration {
+	b.refill(now)
+	if now.Before(b.blockedUntil) {
+		return b.blockedUntil.Sub(now)
+	}
+	if b.tokens >= 1 {
+		return 0
+	}
+	return max(time.Duration((1-b.tokens)/b.rate*float64(time.Second)), time.Millisecond)
+}
+
+func (b *tokenBucket) take() {
+	b.tokens--
+}
+
+// block запрещает отправку до until, как просит Telegram в ответе 429
+func (b *tokenBucket) block(until time.Time) {
+	if !until.After(b.blockedUntil) {
+		return
+	}
+	// После паузы разрешаем одно сообщение, дальше — по обычному лимиту
+	b.blockedUntil = until
+	b.tokens = 1
+	b.last = until
+}
+
+func (b *tokenBucket) empty(now time.Time) bool {
+	b.refill(now)
+	return b.tokens < 1
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst && !now.Before(b.blockedUntil)
+}
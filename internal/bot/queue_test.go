@@ -0,0 +1,144 @@
+package bot
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(groupRate, groupBurst, now)
+	for i := 0; i < groupBurst; i++ {
+		require.Zero(t, b.delay(now))
+		b.take()
+	}
+	// 20 сообщений в минуту — следующее через 3 секунды
+	assert.Equal(t, 3*time.Second, b.delay(now).Round(time.Millisecond))
+	assert.Zero(t, b.delay(now.Add(3*time.Second)))
+
+	b.block(now.Add(10 * time.Second))
+	assert.Equal(t, 5*time.Second, b.delay(now.Add(5*time.Second)))
+	assert.Zero(t, b.delay(now.Add(10*time.Second)))
+}
+
+func TestSendQueueNext(t *testing.T) {
+	q := NewSendQueue(nil)
+	now := time.Now()
+	for _, job := range []*sendJob{
+		{chatID: -1, priority: priorityEdit, seq: 0},
+		{chatID: -2, priority: priorityAnnouncement, seq: 1},
+		{chatID: 7, priority: priorityMessage, seq: 2},
+		{chatID: -2, priority: priorityAnnouncement, seq: 3},
+	} {
+		q.push(job)
+	}
+
+	// Оповещения уходят раньше изменений, внутри приоритета — по очереди
+	var order []uint64
+	for len(q.jobs) > 0 {
+		job, _ := q.next(now)
+		require.NotNil(t, job)
+		order = append(order, job.seq)
+	}
+	assert.Equal(t, []uint64{1, 3, 2, 0}, order)
+
+	// Чат, упёршийся в лимит, не задерживает остальные
+	q.chatBucket(-2, now).block(now.Add(time.Minute))
+	q.push(&sendJob{chatID: -2, priority: priorityAnnouncement, seq: 4})
+	q.push(&sendJob{chatID: -3, priority: priorityEdit, seq: 5})
+	job, _ := q.next(now)
+	require.NotNil(t, job)
+	assert.Equal(t, uint64(5), job.seq)
+
+	job, wait := q.next(now)
+	assert.Nil(t, job)
+	assert.Equal(t, time.Minute, wait)
+}
+
+func TestSendQueueRetriesAfter429(t *testing.T) {
+	q := NewSendQueue(nil)
+	q.Start()
+	defer q.Stop()
+
+	attempts := 0
+	err := q.do(-100, priorityAnnouncement, func() error {
+		attempts++
+		if attempts == 1 {
+			return &tgbotapi.Error{Code: 429, Message: "Too Many Requests: retry after 1"}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestThrottledChatDoesNotBlockOthers(t *testing.T) {
+	q := NewSendQueue(nil)
+	q.Start()
+	defer q.Stop()
+
+	var mu sync.Mutex
+	var sent []int64
+	attempts := make(map[int64]int)
+	// Оповещения в два канала отправляются так же, как из монитора
+	forEachParallel([]int64{-1, -2}, maxParallelUpdates, func(chatID int64) {
+		err := q.do(chatID, priorityAnnouncement, func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts[chatID]++
+			if chatID == -1 && attempts[chatID] == 1 {
+				return &tgbotapi.Error{Code: 429, Message: "Too Many Requests: retry after 1"}
+			}
+			sent = append(sent, chatID)
+			return nil
+		})
+		assert.NoError(t, err)
+	})
+
+	// Второй канал получил оповещение, пока первый ждал retry_after
+	assert.Equal(t, []int64{-2, -1}, sent)
+	assert.Equal(t, 2, attempts[-1])
+}
+
+func TestSendQueueBlocksGlobalWhenExhausted(t *testing.T) {
+	throttled := func() error {
+		return &tgbotapi.Error{Code: 429, Message: "Too Many Requests", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 30}}
+	}
+	now := time.Now()
+
+	// Общий лимит не израсходован — ограничен только этот чат
+	q := NewSendQueue(nil)
+	q.execute(&sendJob{chatID: -1, send: throttled, result: make(chan error, 1)})
+	assert.Zero(t, q.global.delay(now))
+	assert.Greater(t, q.chatBucket(-1, now).delay(now), 25*time.Second)
+
+	// Общий лимит израсходован — ждут все чаты
+	q = NewSendQueue(nil)
+	q.global.tokens = 0
+	q.execute(&sendJob{chatID: -1, send: throttled, result: make(chan error, 1)})
+	assert.Greater(t, q.global.delay(time.Now()), 25*time.Second)
+	assert.Len(t, q.jobs, 1)
+}
+
+func TestRetryAfter(t *testing.T) {
+	wait, ok := retryAfter(&tgbotapi.Error{Code: 429, Message: "Too Many Requests", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 7}})
+	assert.True(t, ok)
+	assert.Equal(t, 7*time.Second, wait)
+
+	wait, ok = retryAfter(&tgbotapi.Error{Code: 429, Message: "Too Many Requests: retry after 12"})
+	assert.True(t, ok)
+	assert.Equal(t, 12*time.Second, wait)
+
+	_, ok = retryAfter(&tgbotapi.Error{Code: 400, Message: "Bad Request: chat not found"})
+	assert.False(t, ok)
+	_, ok = retryAfter(errors.New("connection reset"))
+	assert.False(t, ok)
+	_, ok = retryAfter(nil)
+	assert.False(t, ok)
+}
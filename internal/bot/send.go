@@ -0,0 +1,81 @@
+package bot
+
+import (
+	"encoding/json"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// tgbotapi 5.5 не знает про темы форумов (message_thread_id), поэтому
+// сообщения в тему отправляются запросом, собранным вручную. Сообщения в чат
+// без темы идут обычным Send.
+
+// send отправляет сообщение через очередь
+func (q *SendQueue) send(chatID int64, p priority, c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	var msg tgbotapi.Message
+	err := q.do(chatID, p, func() (err error) {
+		msg, err = q.bot.Send(c)
+		return err
+	})
+	return msg, err
+}
+
+// request выполняет запрос без ответа-сообщения, например удаление, через очередь
+func (q *SendQueue) request(chatID int64, p priority, c tgbotapi.Chattable) error {
+	return q.do(chatID, p, func() error {
+		_, err := q.bot.Request(c)
+		return err
+	})
+}
+
+// Notify отправляет пользователю личное сообщение
+func (q *SendQueue) Notify(userID int64, text string) error {
+	_, err := q.send(userID, priorityMessage, tgbotapi.NewMessage(userID, text))
+	return err
+}
+
+// sendText отправляет текст в чат или в тему threadID
+func (q *SendQueue) sendText(chatID int64, threadID int, p priority, text, parseMode string) (tgbotapi.Message, error) {
+	if threadID == 0 {
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ParseMode = parseMode
+		return q.send(chatID, p, msg)
+	}
+
+	params := tgbotapi.Params{}
+	params.AddNonZero64("chat_id", chatID)
+	params.AddNonZero("message_thread_id", threadID)
+	params.AddNonEmpty("text", text)
+	params.AddNonEmpty("parse_mode", parseMode)
+	return q.requestMessage(chatID, p, "sendMessage", params)
+}
+
+// sendPhotoURL отправляет фото по ссылке с подписью в чат или в тему threadID
+func (q *SendQueue) sendPhotoURL(chatID int64, threadID int, p priority, photoURL, caption, parseMode string) (tgbotapi.Message, error) {
+	if threadID == 0 {
+		photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileURL(photoURL))
+		photo.Caption = caption
+		photo.ParseMode = parseMode
+		return q.send(chatID, p, photo)
+	}
+
+	params := tgbotapi.Params{}
+	params.AddNonZero64("chat_id", chatID)
+	params.AddNonZero("message_thread_id", threadID)
+	params.AddNonEmpty("photo", photoURL)
+	params.AddNonEmpty("caption", caption)
+	params.AddNonEmpty("parse_mode", parseMode)
+	return q.requestMessage(chatID, p, "sendPhoto", params)
+}
+
+func (q *SendQueue) requestMessage(chatID int64, p priority, method string, params tgbotapi.Params) (tgbotapi.Message, error) {
+	var message tgbotapi.Message
+	err := q.do(chatID, p, func() error {
+		resp, err := q.bot.MakeRequest(method, params)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(resp.Result, &message)
+	})
+	return message, err
+}
@@ -0,0 +1,62 @@
+package bot
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// sendFailure — ошибка отправки в чат подписки. Постоянные ошибки повторятся
+// на каждом стриме, пока владелец не исправит чат, поэтому подписка с такой
+// ошибкой ставится на паузу. Остальные (сеть, 429, 5xx, ошибки разметки)
+// только учитываются.
+type sendFailure struct {
+	Permanent bool
+	// Reason — причина для владельца подписки
+	Reason string
+}
+
+// permanentSendErrors — описания ошибок 400, которые не пройдут сами.
+// Telegram не даёт кодов ошибок, поэтому сверяемся по тексту.
+var permanentSendErrors = []struct {
+	match  string
+	reason string
+}{
+	{"chat not found", "чат не найден или бот удалён из него"},
+	{"bot is not a member", "бот удалён из чата"},
+	{"bot was kicked", "бот удалён из чата"},
+	{"not enough rights", "у бота нет прав писать в чат"},
+	{"have no rights", "у бота нет прав писать в чат"},
+	{"need administrator rights", "бот не администратор канала"},
+	{"chat_admin_required", "бот не администратор канала"},
+	{"chat_write_forbidden", "боту запрещено писать в чат"},
+	{"message thread not found", "тема форума удалена"},
+	{"topic_deleted", "тема форума удалена"},
+	{"topic_closed", "тема форума закрыта"},
+	{"upgraded to a supergroup", "группа стала супергруппой, добавьте её заново через /bind"},
+}
+
+// classifySendError разбирает ошибку Telegram API
+func classifySendError(err error) sendFailure {
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) {
+		return sendFailure{Reason: err.Error()}
+	}
+
+	description := strings.ToLower(apiErr.Message)
+	for _, known := range permanentSendErrors {
+		if strings.Contains(description, known.match) {
+			return sendFailure{Permanent: true, Reason: known.reason}
+		}
+	}
+	if apiErr.MigrateToChatID != 0 {
+		return sendFailure{Permanent: true, Reason: "группа стала супергруппой, добавьте её заново через /bind"}
+	}
+	// 403 — бота удалили или заблокировали, даже если текст незнакомый
+	if apiErr.Code == http.StatusForbidden {
+		return sendFailure{Permanent: true, Reason: "бот не может писать в чат: " + apiErr.Message}
+	}
+	return sendFailure{Reason: apiErr.Message}
+}
@@ -0,0 +1,38 @@
+package bot
+
+import (
+	"errors"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifySendError(t *testing.T) {
+	permanent := map[string]*tgbotapi.Error{
+		"чат не найден или бот удалён из него": {Code: 400, Message: "Bad Request: chat not found"},
+		"бот удалён из чата":                   {Code: 403, Message: "Forbidden: bot was kicked from the supergroup chat"},
+		"у бота нет прав писать в чат":         {Code: 400, Message: "Bad Request: not enough rights to send text messages to the chat"},
+		"тема форума закрыта":                  {Code: 400, Message: "Bad Request: TOPIC_CLOSED"},
+		"группа стала супергруппой, добавьте её заново через /bind": {
+			Code: 400, Message: "Bad Request: group chat was migrated", ResponseParameters: tgbotapi.ResponseParameters{MigrateToChatID: -100123},
+		},
+	}
+	for reason, err := range permanent {
+		failure := classifySendError(err)
+		assert.True(t, failure.Permanent, err.Message)
+		assert.Equal(t, reason, failure.Reason, err.Message)
+	}
+
+	// Незнакомый 403 тоже постоянный
+	assert.True(t, classifySendError(&tgbotapi.Error{Code: 403, Message: "Forbidden: user is deactivated"}).Permanent)
+
+	for _, err := range []error{
+		&tgbotapi.Error{Code: 429, Message: "Too Many Requests: retry after 5", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 5}},
+		&tgbotapi.Error{Code: 400, Message: "Bad Request: can't parse entities: Character '.' is reserved"},
+		&tgbotapi.Error{Code: 502, Message: "Bad Gateway"},
+		errors.New("dial tcp: i/o timeout"),
+	} {
+		assert.False(t, classifySendError(err).Permanent, err.Error())
+	}
+}
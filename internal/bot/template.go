@@ -0,0 +1,211 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+const defaultAnnouncementTemplate = "🔴 *{{.Streamer}}* начал стрим\\!\n📝 *Название:* {{.Title}}\n🎮 *Игра:* {{.Game}}\n👉 {{.URL}}"
+
+const (
+	// Максимальная длина текста сообщения в Telegram
+	maxMessageLength = 4096
+	// Максимальная длина подписи к фото
+	maxCaptionLength = 1024
+	uptimeStep       = 5 * time.Minute
+)
+
+// AnnouncementData — значения, доступные в шаблоне оповещения. Все строки
+// уже экранированы для MarkdownV2.
+type AnnouncementData struct {
+	Streamer  string
+	Title     string
+	Game      string
+	Viewers   int
+	Uptime    string
+	URL       string
+	Thumbnail string
+	Tags      []string
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// announcementPlaceholders — подсказка для пользователя в /template
+const announcementPlaceholders = `{{.Streamer}} — имя стримера
+{{.Title}} — название стрима
+{{.Game}} — игра
+{{.Viewers}} — количество зрителей
+{{.Uptime}} — длительность стрима
+{{.URL}} — ссылка на стрим
+{{.Thumbnail}} — ссылка на превью
+{{join .Tags ", "}} — теги`
+
+func newAnnouncementData(username string, info StreamInfo) AnnouncementData {
+	streamer := info.UserName
+	if streamer == "" {
+		streamer = username
+	}
+
+	tags := make([]string, 0, len(info.Tags))
+	for _, tag := range info.Tags {
+		tags = append(tags, escapeMarkdown(tag))
+	}
+
+	return AnnouncementData{
+		Streamer:  escapeMarkdown(streamer),
+		Title:     escapeMarkdown(info.Title),
+		Game:      escapeMarkdown(info.GameName),
+		Viewers:   info.ViewerCount,
+		Uptime:    escapeMarkdown(formatUptime(info.StartedAt)),
+		URL:       escapeMarkdown("https://twitch.tv/" + username),
+		Thumbnail: escapeMarkdown(info.ThumbnailURL),
+		Tags:      tags,
+	}
+}
+
+// sampleAnnouncementData используется для проверки и предпросмотра шаблонов
+func sampleAnnouncementData(username string) AnnouncementData {
+	return newAnnouncementData(username, StreamInfo{
+		Title:        "Тестовый стрим (пример)",
+		ViewerCount:  123,
+		GameName:     "Just Chatting",
+		ThumbnailURL: "https://static-cdn.jtvnw.net/previews-ttv/live_user_" + username + "-1280x720.jpg",
+		Tags:         []string{"Русский", "Игры"},
+		StartedAt:    time.Now().Add(-75 * time.Minute),
+	})
+}
+
+// formatUptime округляет длительность стрима до uptimeStep, чтобы оповещение
+// не редактировалось каждую минуту
+func formatUptime(startedAt time.Time) string {
+	if startedAt.IsZero() {
+		return "—"
+	}
+
+	return formatDuration(time.Since(startedAt).Truncate(uptimeStep))
+}
+
+func formatDuration(d time.Duration) string {
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+	if hours == 0 {
+		return fmt.Sprintf("%dм", minutes)
+	}
+	return fmt.Sprintf("%dч %02dм", hours, minutes)
+}
+
+func renderAnnouncement(text string, data AnnouncementData) (string, error) {
+	if text == "" {
+		text = defaultAnnouncementTemplate
+	}
+
+	tmpl, err := template.New("announcement").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// validateAnnouncementTemplate проверяет шаблон до сохранения, чтобы
+// сломанный шаблон не остановил оповещения
+func validateAnnouncementTemplate(text string, username string) error {
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("шаблон пустой")
+	}
+
+	rendered, err := renderAnnouncement(text, sampleAnnouncementData(username))
+	if err != nil {
+		return fmt.Errorf("ошибка в шаблоне: %w", err)
+	}
+	if strings.TrimSpace(rendered) == "" {
+		return fmt.Errorf("шаблон даёт пустое сообщение")
+	}
+	if utf8.RuneCountInString(rendered) > maxMessageLength {
+		return fmt.Errorf("сообщение длиннее %d символов", maxMessageLength)
+	}
+	return checkMarkdownV2(rendered)
+}
+
+// checkMarkdownV2 ищет ошибки, из-за которых Telegram не примет сообщение:
+// неэкранированные служебные символы и незакрытую разметку
+func checkMarkdownV2(text string) error {
+	runes := []rune(text)
+	open := make(map[string]bool)
+	inLink := false
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		if r == '\\' {
+			if i+1 >= len(runes) {
+				return fmt.Errorf("сообщение заканчивается на \\")
+			}
+			i++
+			continue
+		}
+
+		if open["`"] && r != '`' {
+			continue
+		}
+
+		switch r {
+		case '*', '~', '`':
+			open[string(r)] = !open[string(r)]
+		case '_':
+			marker := "_"
+			if i+1 < len(runes) && runes[i+1] == '_' {
+				marker = "__"
+				i++
+			}
+			open[marker] = !open[marker]
+		case '|':
+			if i+1 >= len(runes) || runes[i+1] != '|' {
+				return fmt.Errorf("символ | должен быть экранирован: \\|")
+			}
+			i++
+			open["||"] = !open["||"]
+		case '[':
+			if inLink {
+				return fmt.Errorf("символ [ должен быть экранирован: \\[")
+			}
+			inLink = true
+		case ']':
+			if !inLink || i+1 >= len(runes) || runes[i+1] != '(' {
+				return fmt.Errorf("символ ] должен быть экранирован: \\]")
+			}
+			inLink = false
+			// Пропускаем адрес ссылки до закрывающей скобки
+			i += 2
+			for ; i < len(runes) && runes[i] != ')'; i++ {
+				if runes[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(runes) {
+				return fmt.Errorf("не закрыта ссылка: нет )")
+			}
+		case '>', '#', '+', '-', '=', '{', '}', '.', '!', '(', ')':
+			return fmt.Errorf("символ %c должен быть экранирован: \\%c", r, r)
+		}
+	}
+
+	if inLink {
+		return fmt.Errorf("не закрыта ссылка: нет ]")
+	}
+	for marker, isOpen := range open {
+		if isOpen {
+			return fmt.Errorf("не закрыта разметка %s", marker)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderAnnouncementEscapesValues(t *testing.T) {
+	data := newAnnouncementData("streamer_1", StreamInfo{
+		UserName: "Streamer_1",
+		Title:    "Новый *стрим* (день 1)!",
+		GameName: "Just Chatting",
+	})
+
+	text, err := renderAnnouncement("", data)
+	assert.NoError(t, err)
+	assert.Contains(t, text, `Новый \*стрим\* \(день 1\)\!`)
+	assert.Contains(t, text, `https://twitch\.tv/streamer\_1`)
+	assert.NoError(t, checkMarkdownV2(text), "шаблон по умолчанию должен быть корректным MarkdownV2")
+}
+
+func TestValidateAnnouncementTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		valid    bool
+	}{
+		{"подстановки и разметка", "*{{.Streamer}}* в эфире\\! {{.Title}} [смотреть]({{.URL}})", true},
+		{"теги", "{{join .Tags \", \"}}", true},
+		{"неизвестное поле", "{{.Unknown}}", false},
+		{"синтаксическая ошибка", "{{.Title", false},
+		{"неэкранированный символ", "Стрим начался!", false},
+		{"незакрытая разметка", "*{{.Streamer}}", false},
+		{"пустой шаблон", "   ", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAnnouncementTemplate(tt.template, "streamer")
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -1,49 +1,221 @@
 package config
 
 import (
-	"gopkg.in/yaml.v3"
-	"log"
-	"os"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
 )
 
+// Config собирается из значений по умолчанию, YAML-файла, .env, переменных
+// окружения и флагов командной строки — см. Load. Имя переменной окружения —
+// yaml-тег в верхнем регистре, имя флага — yaml-тег через дефис.
+// Поля с тегом secret скрываются в `config print --redacted`.
 type Config struct {
-	TelegramToken      string `yaml:"telegram_token"`
+	TelegramToken      string `yaml:"telegram_token" secret:"true"`
 	TwitchClientID     string `yaml:"twitch_client_id"`
-	TwitchClientSecret string `yaml:"twitch_client_secret"`
-	TwitchOAuthToken   string `yaml:"twitch_oauth_token"`
-	TwitchOAuthExpires int64  `yaml:"twitch_oauth_expires"`
-	DatabaseUser       string `yaml:"database_user"`
-	DatabasePassword   string `yaml:"database_password"`
-	DatabaseHost       string `yaml:"database_host"`
-	DatabasePort       string `yaml:"database_port"`
-	DatabaseName       string `yaml:"database_name"`
+	TwitchClientSecret string `yaml:"twitch_client_secret" secret:"true"`
+	// Адреса Twitch API; пустые значения — api.twitch.tv и id.twitch.tv
+	TwitchHelixURL string `yaml:"twitch_helix_url"`
+	TwitchAuthURL  string `yaml:"twitch_auth_url"`
+	// TwitchTokenStore — где хранить токен Twitch: postgres (по умолчанию), file или memory
+	TwitchTokenStore string `yaml:"twitch_token_store"`
+	// TwitchTokenFile — путь к файлу токена для twitch_token_store: file
+	TwitchTokenFile string `yaml:"twitch_token_file"`
+
+	DatabaseUser     string `yaml:"database_user"`
+	DatabasePassword string `yaml:"database_password" secret:"true"`
+	DatabaseHost     string `yaml:"database_host"`
+	DatabasePort     string `yaml:"database_port"`
+	DatabaseName     string `yaml:"database_name"`
+
+	YooKassaShopID    string `yaml:"yookassa_shop_id"`
+	YooKassaSecretKey string `yaml:"yookassa_secret_key" secret:"true"`
+	// YooKassaVerifyIP принимает уведомления только с адресов YooKassa
+	YooKassaVerifyIP bool `yaml:"yookassa_verify_ip"`
+	// TrustForwardedFor — бот работает за reverse proxy, адрес клиента берётся
+	// из X-Forwarded-For
+	TrustForwardedFor bool `yaml:"trust_forwarded_for"`
+
+	// EventSub включается, если заданы адрес колбэка и секрет
+	EventSubCallbackURL string `yaml:"eventsub_callback_url"`
+	EventSubSecret      string `yaml:"eventsub_secret" secret:"true"`
+	// PollingEnabled оставляет опрос helix/streams как запасной вариант при EventSub
+	PollingEnabled bool `yaml:"polling_enabled"`
+
+	// ConversationStore — где хранить диалоги с пользователями: postgres (по умолчанию) или memory
+	ConversationStore string `yaml:"conversation_store"`
+
+	// ListenAddr — адрес HTTP-сервера для вебхуков YooKassa и EventSub
+	ListenAddr string `yaml:"listen_addr"`
+	// PollInterval — период опроса Twitch монитором
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// ProExpiryCheckInterval — как часто снимать просроченные подписки Pro
+	ProExpiryCheckInterval time.Duration `yaml:"pro_expiry_check_interval"`
+
+	// Цены Pro на 1, 3 и 12 месяцев в формате YooKassa, например 50.00
+	ProPrice         string `yaml:"pro_price"`
+	ProPrice3Months  string `yaml:"pro_price_3_months"`
+	ProPrice12Months string `yaml:"pro_price_12_months"`
+	ProCurrency      string `yaml:"pro_currency"`
+	// PaymentReturnURL — куда YooKassa вернёт пользователя после оплаты
+	PaymentReturnURL string `yaml:"payment_return_url"`
+	// BotLink добавляется в конец оповещений пользователей без Pro;
+	// пустое значение отключает подпись
+	BotLink string `yaml:"bot_link"`
 }
 
-func LoadConfig(filename string) Config {
-	var cfg Config
-	file, err := os.Open(filename)
-	if err != nil {
-		log.Fatalf("Ошибка при открытии %s: %v", filename, err)
+// Defaults — значения, которые действуют, если их не переопределил ни один источник
+func Defaults() Config {
+	return Config{
+		TwitchTokenStore:  "postgres",
+		TwitchTokenFile:   "twitch_token.json",
+		DatabaseHost:      "localhost",
+		DatabasePort:      "5432",
+		ConversationStore: "postgres",
+
+		YooKassaVerifyIP: true,
+
+		ListenAddr:             ":8080",
+		PollInterval:           10 * time.Second,
+		ProExpiryCheckInterval: time.Hour,
+
+		ProPrice:         "50.00",
+		ProPrice3Months:  "135.00",
+		ProPrice12Months: "480.00",
+		ProCurrency:      "RUB",
+		PaymentReturnURL: "https://t.me/Twitchmanannouncer_bot",
+		BotLink:          "https://t.me/Twitchmanannouncer_bot",
 	}
-	defer file.Close()
+}
 
-	decoder := yaml.NewDecoder(file)
-	if err := decoder.Decode(&cfg); err != nil {
-		log.Fatalf("Ошибка при чтении %s: %v", filename, err)
+// priceRegex — сумма в формате YooKassa: целая часть и ровно два знака после точки
+var priceRegex = regexp.MustCompile(`^[0-9]+\.[0-9]{2}$`)
+
+// currencyRegex — трёхбуквенный код валюты ISO 4217
+var currencyRegex = regexp.MustCompile(`^[A-Z]{3}$`)
+
+func (c Config) EventSubEnabled() bool {
+	return c.EventSubCallbackURL != "" && c.EventSubSecret != ""
+}
+
+// PollingActive сообщает, нужно ли опрашивать helix/streams: без EventSub
+// опрос работает всегда
+func (c Config) PollingActive() bool {
+	return !c.EventSubEnabled() || c.PollingEnabled
+}
+
+func (c Config) DatabaseURL() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.DatabaseUser, c.DatabasePassword),
+		Host:     net.JoinHostPort(c.DatabaseHost, c.DatabasePort),
+		Path:     "/" + c.DatabaseName,
+		RawQuery: "sslmode=disable",
 	}
-	return cfg
+	return u.String()
 }
 
-func SaveConfig(filename string, cfg Config) {
-	file, err := os.Create(filename)
-	if err != nil {
-		log.Fatalf("Не удалось сохранить %s: %v", filename, err)
+// ValidateDatabase проверяет только настройки подключения к базе: их
+// достаточно для подкоманды migrate
+func (c Config) ValidateDatabase() error {
+	var errs []error
+	for _, field := range []struct{ value, name string }{
+		{c.DatabaseUser, "database_user"},
+		{c.DatabaseHost, "database_host"},
+		{c.DatabasePort, "database_port"},
+		{c.DatabaseName, "database_name"},
+	} {
+		if field.value == "" {
+			errs = append(errs, fmt.Errorf("не задан %s", field.name))
+		}
 	}
-	defer file.Close()
+	return errors.Join(errs...)
+}
 
-	encoder := yaml.NewEncoder(file)
-	encoder.SetIndent(2)
-	if err := encoder.Encode(&cfg); err != nil {
-		log.Fatalf("Ошибка при сохранении %s: %v", err)
+// Validate проверяет все поля сразу и возвращает все найденные ошибки
+func (c Config) Validate() error {
+	var errs []error
+	required := func(value, name string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("не задан %s", name))
+		}
+	}
+	positive := func(value time.Duration, name string) {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("%s должен быть больше нуля", name))
+		}
+	}
+	price := func(value, name string) {
+		if !priceRegex.MatchString(value) || strings.Trim(value, "0.") == "" {
+			errs = append(errs, fmt.Errorf("%s: ожидается положительная сумма вида 50.00, получено %q", name, value))
+		}
 	}
+	httpURL := func(value, name string) {
+		if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s должен быть http- или https-адресом", name))
+		}
+	}
+	oneOf := func(value, name string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s: неизвестное значение %q, допустимо: %v", name, value, allowed))
+	}
+
+	required(c.TelegramToken, "telegram_token")
+	required(c.TwitchClientID, "twitch_client_id")
+	required(c.TwitchClientSecret, "twitch_client_secret")
+	if err := c.ValidateDatabase(); err != nil {
+		errs = append(errs, err)
+	}
+
+	oneOf(c.TwitchTokenStore, "twitch_token_store", "postgres", "file", "memory")
+	if c.TwitchTokenStore == "file" {
+		required(c.TwitchTokenFile, "twitch_token_file")
+	}
+	oneOf(c.ConversationStore, "conversation_store", "postgres", "memory")
+
+	if (c.YooKassaShopID == "") != (c.YooKassaSecretKey == "") {
+		errs = append(errs, fmt.Errorf("yookassa_shop_id и yookassa_secret_key задаются вместе"))
+	}
+
+	if (c.EventSubCallbackURL == "") != (c.EventSubSecret == "") {
+		errs = append(errs, fmt.Errorf("eventsub_callback_url и eventsub_secret задаются вместе"))
+	}
+	if c.EventSubSecret != "" && (len(c.EventSubSecret) < 10 || len(c.EventSubSecret) > 100) {
+		errs = append(errs, fmt.Errorf("eventsub_secret должен быть длиной от 10 до 100 символов"))
+	}
+	if c.EventSubCallbackURL != "" {
+		if u, err := url.Parse(c.EventSubCallbackURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("eventsub_callback_url должен быть https-адресом"))
+		}
+	}
+
+	required(c.ListenAddr, "listen_addr")
+	if c.ListenAddr != "" {
+		if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+			errs = append(errs, fmt.Errorf("listen_addr: неверный адрес %q, ожидается host:port или :port", c.ListenAddr))
+		}
+	}
+	positive(c.PollInterval, "poll_interval")
+	positive(c.ProExpiryCheckInterval, "pro_expiry_check_interval")
+
+	price(c.ProPrice, "pro_price")
+	price(c.ProPrice3Months, "pro_price_3_months")
+	price(c.ProPrice12Months, "pro_price_12_months")
+	if !currencyRegex.MatchString(c.ProCurrency) {
+		errs = append(errs, fmt.Errorf("pro_currency: ожидается код валюты вида RUB, получено %q", c.ProCurrency))
+	}
+	httpURL(c.PaymentReturnURL, "payment_return_url")
+	if c.BotLink != "" {
+		httpURL(c.BotLink, "bot_link")
+	}
+
+	return errors.Join(errs...)
 }
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultConfigFile = "config.yaml"
+	defaultEnvFile    = ".env"
+)
+
+// field — поле Config с именами в YAML, окружении и флагах
+type field struct {
+	index  int
+	yaml   string
+	env    string
+	flag   string
+	secret bool
+}
+
+func fields() []field {
+	t := reflect.TypeOf(Config{})
+	result := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		result = append(result, field{
+			index:  i,
+			yaml:   name,
+			env:    strings.ToUpper(name),
+			flag:   strings.ReplaceAll(name, "_", "-"),
+			secret: t.Field(i).Tag.Get("secret") == "true",
+		})
+	}
+	return result
+}
+
+// Load собирает конфигурацию из источников по возрастанию приоритета:
+// значения по умолчанию → YAML (--config, по умолчанию config.yaml) → файл
+// .env (--env-file) → переменные окружения → флаги командной строки.
+// Возвращает аргументы после флагов, то есть подкоманду. Конфигурация не
+// проверяется, для этого есть Validate.
+func Load(args []string) (Config, []string, error) {
+	cfg := Defaults()
+
+	fs := flag.NewFlagSet("bot", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := fs.String("config", defaultConfigFile, "путь к YAML-файлу конфигурации")
+	envFile := fs.String("env-file", defaultEnvFile, "путь к .env файлу")
+
+	flagValues := make(map[string]*fieldFlag)
+	for _, f := range fields() {
+		value := &fieldFlag{isBool: reflect.TypeOf(Config{}).Field(f.index).Type.Kind() == reflect.Bool}
+		flagValues[f.flag] = value
+		fs.Var(value, f.flag, f.yaml)
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, fmt.Errorf("ошибка разбора флагов: %w", err)
+	}
+	setFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	if err := loadYAML(&cfg, *configFile, setFlags["config"]); err != nil {
+		return cfg, nil, err
+	}
+
+	dotenv, err := godotenv.Read(*envFile)
+	if err != nil && !(errors.Is(err, os.ErrNotExist) && !setFlags["env-file"]) {
+		return cfg, nil, fmt.Errorf("ошибка при чтении %s: %w", *envFile, err)
+	}
+
+	var errs []error
+	v := reflect.ValueOf(&cfg).Elem()
+	for _, f := range fields() {
+		apply := func(value, source string) {
+			if err := setField(v.Field(f.index), value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", source, err))
+			}
+		}
+
+		if value, ok := dotenv[f.env]; ok {
+			apply(value, *envFile+": "+f.env)
+		}
+		if value, ok := os.LookupEnv(f.env); ok {
+			apply(value, "переменная "+f.env)
+		}
+		if setFlags[f.flag] {
+			apply(flagValues[f.flag].value, "флаг --"+f.flag)
+		}
+	}
+
+	return cfg, fs.Args(), errors.Join(errs...)
+}
+
+// fieldFlag — флаг для поля Config; булевы поля можно задать просто --flag
+type fieldFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *fieldFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *fieldFlag) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+func (f *fieldFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// loadYAML читает YAML поверх cfg. Отсутствие файла по умолчанию не ошибка:
+// всё можно задать через окружение.
+func loadYAML(cfg *Config, filename string, explicit bool) error {
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка при открытии %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	if err := yaml.NewDecoder(file).Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("ошибка при чтении %s: %w", filename, err)
+	}
+	return nil
+}
+
+func setField(v reflect.Value, value string) error {
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(value)
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("ожидается true или false, получено %q", value)
+		}
+		v.SetBool(b)
+	case int, int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("ожидается целое число, получено %q", value)
+		}
+		v.SetInt(n)
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("ожидается длительность вида 10s или 1h, получено %q", value)
+		}
+		v.SetInt(int64(d))
+	default:
+		return fmt.Errorf("неподдерживаемый тип поля %s", v.Type())
+	}
+	return nil
+}
+
+// Redacted возвращает копию конфигурации со скрытыми секретами
+func (c Config) Redacted() Config {
+	v := reflect.ValueOf(&c).Elem()
+	for _, f := range fields() {
+		if f.secret && v.Field(f.index).String() != "" {
+			v.Field(f.index).SetString("***")
+		}
+	}
+	return c
+}
+
+// Print выводит итоговую конфигурацию в формате YAML
+func Print(w io.Writer, cfg Config) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(cfg); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadLayers(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "bot.yaml")
+	envFile := filepath.Join(dir, ".env")
+
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+telegram_token: yaml-token
+twitch_client_id: yaml-client
+database_name: yaml-db
+database_user: yaml-user
+`), 0o600))
+	require.NoError(t, os.WriteFile(envFile, []byte("TWITCH_CLIENT_ID=dotenv-client\nDATABASE_NAME=dotenv-db\n"), 0o600))
+	t.Setenv("DATABASE_NAME", "env-db")
+	t.Setenv("DATABASE_USER", "env-user")
+
+	cfg, args, err := Load([]string{
+		"--config", configFile,
+		"--env-file", envFile,
+		"--database-user", "flag-user",
+		"--polling-enabled",
+		"migrate", "status",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"migrate", "status"}, args)
+	assert.Equal(t, "yaml-token", cfg.TelegramToken)
+	assert.Equal(t, "dotenv-client", cfg.TwitchClientID)
+	assert.Equal(t, "env-db", cfg.DatabaseName)
+	assert.Equal(t, "flag-user", cfg.DatabaseUser)
+	assert.True(t, cfg.PollingEnabled)
+	// Значение по умолчанию
+	assert.Equal(t, "5432", cfg.DatabasePort)
+}
+
+func TestLoadMissingExplicitConfig(t *testing.T) {
+	_, _, err := Load([]string{"--config", filepath.Join(t.TempDir(), "missing.yaml")})
+	assert.Error(t, err)
+}
+
+func TestValidateAggregatesErrors(t *testing.T) {
+	cfg := Defaults()
+	cfg.ConversationStore = "redis"
+	cfg.EventSubSecret = "short"
+
+	err := cfg.Validate()
+	require.Error(t, err)
+	for _, want := range []string{"telegram_token", "twitch_client_secret", "database_name", "conversation_store", "eventsub_secret"} {
+		assert.Contains(t, err.Error(), want)
+	}
+}
+
+func TestValidateDatabase(t *testing.T) {
+	// Для migrate не нужны токены Telegram и Twitch
+	cfg := Defaults()
+	cfg.DatabaseUser = "bot"
+	cfg.DatabaseName = "twitchannouncer"
+	require.NoError(t, cfg.ValidateDatabase())
+	require.Error(t, cfg.Validate())
+
+	cfg.DatabaseName = ""
+	assert.ErrorContains(t, cfg.ValidateDatabase(), "database_name")
+}
+
+func TestValidateRuntimeSettings(t *testing.T) {
+	cfg := Defaults()
+	cfg.ListenAddr = "8080"
+	cfg.PollInterval = 0
+	cfg.ProPrice = "50"
+	cfg.PaymentReturnURL = "t.me/bot"
+
+	err := cfg.Validate()
+	require.Error(t, err)
+	for _, want := range []string{"listen_addr", "poll_interval", "pro_price", "payment_return_url"} {
+		assert.Contains(t, err.Error(), want)
+	}
+	assert.NotContains(t, err.Error(), "pro_currency")
+
+	t.Setenv("POLL_INTERVAL", "1m")
+	cfg, _, err = Load([]string{"--pro-price-12-months", "0.00"})
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, cfg.PollInterval)
+	assert.ErrorContains(t, cfg.Validate(), "pro_price_12_months")
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Defaults()
+	cfg.TelegramToken = "secret"
+	cfg.TwitchClientID = "client"
+
+	redacted := cfg.Redacted()
+	assert.Equal(t, "***", redacted.TelegramToken)
+	assert.Equal(t, "client", redacted.TwitchClientID)
+	assert.Empty(t, redacted.DatabasePassword)
+	assert.Equal(t, "secret", cfg.TelegramToken)
+}
@@ -13,12 +13,106 @@ type UserData struct {
 }
 
 type SubscriptionData struct {
-	ID              int
-	UserID          int64
-	ChannelID       int64
-	TwitchUsername  string
-	LatestMessageID int
-	Checked         bool
-	Live            bool
-	ChannelName     string
+	ID             int
+	UserID         int64
+	ChannelID      int64
+	TwitchUsername string
+	// TwitchUserID — ID стримера в Twitch, не меняется при смене логина
+	TwitchUserID      string
+	TwitchDisplayName string
+	// ChannelName — название чата; у подписок, созданных до поддержки групп, — @username канала
+	ChannelName string
+	// MessageThreadID — тема форума, 0 — весь чат
+	MessageThreadID int
+	// Template — шаблон оповещения text/template, пустой для шаблона по умолчанию
+	Template string
+	// LiveUpdates — редактировать оповещение, пока идёт стрим
+	LiveUpdates bool
+	// OfflineMode — что сделать с оповещением после стрима, одна из OfflineMode*
+	OfflineMode string
+	// PhotoMode — отправлять оповещение фото с превью стрима
+	PhotoMode bool
+	// Paused — оповещения не отправляются: бота удалили из чата, лишили прав
+	// или отправка завершилась постоянной ошибкой
+	Paused bool
+	// PauseReason — почему подписка на паузе, показывается владельцу
+	PauseReason string
+	// PauseSource — что поставило подписку на паузу, одна из PauseSource*
+	PauseSource string
+	// FailureCount — сколько отправок подряд завершились ошибкой
+	FailureCount int
+	// LastError — текст последней ошибки отправки
+	LastError string
+}
+
+const (
+	// PauseSourceChatMember — бота удалили из чата или лишили прав; такие
+	// подписки включаются сами, когда права вернут
+	PauseSourceChatMember = "chat_member"
+	// PauseSourceSendError — Telegram отклонил отправку; включается владельцем
+	PauseSourceSendError = "send_error"
+)
+
+const (
+	OfflineModeDelete   = "delete"
+	OfflineModeSummary  = "summary"
+	OfflineModeKeep     = "keep"
+	OfflineModeSeparate = "separate"
+)
+
+// StreamAnnouncement — состояние оповещения подписки в конкретном канале
+type StreamAnnouncement struct {
+	SubscriptionID int
+	ChannelID      int64
+	MessageID      int
+	Live           bool
+	Checked        bool
+	// IsPhoto — оповещение отправлено фото, текст хранится в подписи
+	IsPhoto bool
+	// LastText — текст оповещения после последней отправки или изменения
+	LastText string
+	EditedAt time.Time
+
+	// Данные для итогов стрима
+	StreamID     string
+	TwitchUserID string
+	Title        string
+	StartedAt    time.Time
+	PeakViewers  int
+	Games        []string
+}
+
+// Payment — платёж за Pro. Payload — последний ответ YooKassa о платеже.
+type Payment struct {
+	ID       string
+	UserID   int64
+	Amount   string
+	Currency string
+	// Months — срок тарифа Pro, на который продлевается подписка
+	Months int
+	Status string
+	// IdempotenceKey — ключ, с которым платёж создан в YooKassa; пустой у
+	// платежей, которые впервые пришли вебхуком
+	IdempotenceKey string
+	Payload        []byte
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Статусы платежа совпадают со статусами YooKassa
+const (
+	PaymentPending           = "pending"
+	PaymentWaitingForCapture = "waiting_for_capture"
+	PaymentSucceeded         = "succeeded"
+	PaymentCanceled          = "canceled"
+)
+
+// ChatTarget — чат или тема форума, куда пользователь может отправлять оповещения
+type ChatTarget struct {
+	UserID   int64
+	ChatID   int64
+	ThreadID int
+	// ChatType — тип чата в Telegram: channel, group или supergroup
+	ChatType string
+	Title    string
 }
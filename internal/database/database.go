@@ -4,18 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"log"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-const proDuration = 30 * 24 * time.Hour
-
 type DB struct {
 	Pool *pgxpool.Pool
+	// OnSubscriptionsChanged вызывается после добавления или удаления подписки
+	OnSubscriptionsChanged func()
 }
 
 func InitDatabase(connStr string) (*DB, error) {
@@ -27,34 +27,21 @@ func InitDatabase(connStr string) (*DB, error) {
 		return nil, fmt.Errorf("ошибка подключения к PostgreSQL: %w", err)
 	}
 
-	_, err = pool.Exec(ctx, `
-	CREATE TABLE IF NOT EXISTS users (
-		telegram_id BIGINT PRIMARY KEY,
-		telegram_username TEXT,
-		pro BOOLEAN DEFAULT FALSE,
-		admin BOOLEAN DEFAULT FALSE
-	)`)
-
-	if err != nil {
-		return nil, fmt.Errorf("ошибка при создании таблицы users: %w", err)
+	if err := pool.Ping(ctx); err != nil {
+		return nil, fmt.Errorf("ошибка подключения к PostgreSQL: %w", err)
 	}
 
-	_, err = pool.Exec(ctx, `
-	CREATE TABLE IF NOT EXISTS subscriptions (
-		id SERIAL PRIMARY KEY,
-		user_id BIGINT NOT NULL REFERENCES users(telegram_id) ON DELETE CASCADE,
-		channel_id BIGINT NOT NULL,
-		twitch_username TEXT NOT NULL,
-		latest_message BIGINT NOT NULL DEFAULT 0,
-		UNIQUE(user_id, channel_id, twitch_username)
-	)`)
+	log.Println("Подключение к PostgreSQL установлено")
+	return &DB{Pool: pool}, nil
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("ошибка при создании таблицы subscriptions: %w", err)
-	}
+// subscriptionColumns — колонки subscriptions в порядке, который ожидает scanSubscription
+const subscriptionColumns = "id, user_id, twitch_username, twitch_user_id, twitch_display_name, channel_id, channel_name, message_thread_id, announcement_template, live_updates, offline_mode, photo_mode, paused, pause_reason, pause_source, failure_count, last_error"
 
-	log.Println("Подключение к PostgreSQL установлено и таблицы созданы")
-	return &DB{Pool: pool}, nil
+func scanSubscription(row pgx.Row) (SubscriptionData, error) {
+	var d SubscriptionData
+	err := row.Scan(&d.ID, &d.UserID, &d.TwitchUsername, &d.TwitchUserID, &d.TwitchDisplayName, &d.ChannelID, &d.ChannelName, &d.MessageThreadID, &d.Template, &d.LiveUpdates, &d.OfflineMode, &d.PhotoMode, &d.Paused, &d.PauseReason, &d.PauseSource, &d.FailureCount, &d.LastError)
+	return d, err
 }
 
 func (db *DB) StoreData(userData UserData, subscriptionData SubscriptionData) error {
@@ -72,8 +59,11 @@ func (db *DB) StoreData(userData UserData, subscriptionData SubscriptionData) er
 
 	var exists int
 	err = db.Pool.QueryRow(ctx, `
-	SELECT 1 FROM subscriptions WHERE user_id = $1 AND channel_id = $2 AND twitch_username = $3
-`, subscriptionData.UserID, subscriptionData.ChannelID, subscriptionData.TwitchUsername).Scan(&exists)
+	SELECT 1 FROM subscriptions
+	WHERE user_id = $1 AND channel_id = $2 AND message_thread_id = $5
+	  AND (twitch_username = $3 OR (twitch_user_id <> '' AND twitch_user_id = $4))
+`, subscriptionData.UserID, subscriptionData.ChannelID, subscriptionData.TwitchUsername, subscriptionData.TwitchUserID,
+		subscriptionData.MessageThreadID).Scan(&exists)
 
 	if err != nil && err != pgx.ErrNoRows {
 		return fmt.Errorf("ошибка при проверке существующей подписки: %w", err)
@@ -84,9 +74,10 @@ func (db *DB) StoreData(userData UserData, subscriptionData SubscriptionData) er
 	}
 
 	_, err = db.Pool.Exec(ctx, `
-		INSERT INTO subscriptions (user_id, channel_id, channel_name, twitch_username)
-		VALUES ($1, $2, $3, $4)
-	`, subscriptionData.UserID, subscriptionData.ChannelID, subscriptionData.ChannelName, subscriptionData.TwitchUsername)
+		INSERT INTO subscriptions (user_id, channel_id, channel_name, message_thread_id, twitch_username, twitch_user_id, twitch_display_name)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, subscriptionData.UserID, subscriptionData.ChannelID, subscriptionData.ChannelName, subscriptionData.MessageThreadID,
+		subscriptionData.TwitchUsername, subscriptionData.TwitchUserID, subscriptionData.TwitchDisplayName)
 
 	if err != nil {
 		if err != nil {
@@ -95,6 +86,7 @@ func (db *DB) StoreData(userData UserData, subscriptionData SubscriptionData) er
 		}
 
 	}
+	db.subscriptionsChanged()
 	return nil
 }
 
@@ -102,8 +94,9 @@ func (db *DB) GetUserSubscriptions(id int64) ([]SubscriptionData, error) {
 	ctx := context.Background()
 	log.Printf("Получение списка подписок для %d", id)
 	rows, err := db.Pool.Query(ctx, `
-		SELECT id, twitch_username, channel_name, channel_id FROM subscriptions
+		SELECT `+subscriptionColumns+` FROM subscriptions
 		WHERE user_id = $1
+		ORDER BY id
 	`, id)
 	if err != nil {
 		log.Printf("Ошибка получения списка подписок: %v", err)
@@ -113,8 +106,8 @@ func (db *DB) GetUserSubscriptions(id int64) ([]SubscriptionData, error) {
 
 	var subs []SubscriptionData
 	for rows.Next() {
-		var d SubscriptionData
-		if err := rows.Scan(&d.ID, &d.TwitchUsername, &d.ChannelName, &d.ChannelID); err != nil {
+		d, err := scanSubscription(rows)
+		if err != nil {
 			log.Printf("Ошибка сканирования: %v", err)
 			return nil, err
 		}
@@ -145,13 +138,23 @@ func (db *DB) DeleteSubscriptionByID(id int) error {
 		DELETE FROM subscriptions
 		WHERE id = $1
 	`, id)
+	if err != nil {
+		return err
+	}
 
-	return err
+	db.subscriptionsChanged()
+	return nil
+}
+
+func (db *DB) subscriptionsChanged() {
+	if db.OnSubscriptionsChanged != nil {
+		db.OnSubscriptionsChanged()
+	}
 }
 
 func (db *DB) GetAllSubscriptions() ([]SubscriptionData, error) {
 	ctx := context.Background()
-	rows, err := db.Pool.Query(ctx, `SELECT user_id, twitch_username, channel_id, channel_name, latest_message FROM subscriptions`)
+	rows, err := db.Pool.Query(ctx, `SELECT `+subscriptionColumns+` FROM subscriptions`)
 	if err != nil {
 		return nil, err
 	}
@@ -159,8 +162,8 @@ func (db *DB) GetAllSubscriptions() ([]SubscriptionData, error) {
 
 	var result []SubscriptionData
 	for rows.Next() {
-		var d SubscriptionData
-		if err := rows.Scan(&d.UserID, &d.TwitchUsername, &d.ChannelID, &d.ChannelName, &d.LatestMessageID); err != nil {
+		d, err := scanSubscription(rows)
+		if err != nil {
 			return nil, err
 		}
 		result = append(result, d)
@@ -168,23 +171,291 @@ func (db *DB) GetAllSubscriptions() ([]SubscriptionData, error) {
 	return result, nil
 }
 
-func (db *DB) GetAllTwitchUsernames() ([]string, error) {
+// UpdateSubscriptionTemplate сохраняет шаблон оповещения; пустая строка
+// возвращает шаблон по умолчанию
+func (db *DB) UpdateSubscriptionTemplate(id int, userID int64, template string) error {
+	ctx := context.Background()
+	cmdTag, err := db.Pool.Exec(ctx, `
+		UPDATE subscriptions
+		SET announcement_template = $1
+		WHERE id = $2 AND user_id = $3
+	`, template, id, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка при обновлении шаблона: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return fmt.Errorf("подписка %d не найдена", id)
+	}
+	return nil
+}
+
+func (db *DB) SetSubscriptionLiveUpdates(id int, userID int64, enabled bool) error {
+	ctx := context.Background()
+	cmdTag, err := db.Pool.Exec(ctx, `
+		UPDATE subscriptions
+		SET live_updates = $1
+		WHERE id = $2 AND user_id = $3
+	`, enabled, id, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка при обновлении настроек подписки: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return fmt.Errorf("подписка %d не найдена", id)
+	}
+	return nil
+}
+
+func (db *DB) SetSubscriptionOfflineMode(id int, userID int64, mode string) error {
+	ctx := context.Background()
+	cmdTag, err := db.Pool.Exec(ctx, `
+		UPDATE subscriptions
+		SET offline_mode = $1
+		WHERE id = $2 AND user_id = $3
+	`, mode, id, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка при обновлении настроек подписки: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return fmt.Errorf("подписка %d не найдена", id)
+	}
+	return nil
+}
+
+func (db *DB) SetSubscriptionPhotoMode(id int, userID int64, enabled bool) error {
 	ctx := context.Background()
-	rows, err := db.Pool.Query(ctx, `SELECT DISTINCT twitch_username FROM subscriptions`)
+	cmdTag, err := db.Pool.Exec(ctx, `
+		UPDATE subscriptions
+		SET photo_mode = $1
+		WHERE id = $2 AND user_id = $3
+	`, enabled, id, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка при обновлении настроек подписки: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return fmt.Errorf("подписка %d не найдена", id)
+	}
+	return nil
+}
+
+// PauseChatSubscriptions ставит на паузу активные подписки на чат и
+// возвращает их, чтобы предупредить владельцев
+func (db *DB) PauseChatSubscriptions(ctx context.Context, chatID int64, reason string) ([]SubscriptionData, error) {
+	return db.querySubscriptions(ctx, `
+		UPDATE subscriptions
+		SET paused = TRUE, pause_reason = $2, pause_source = $3
+		WHERE channel_id = $1 AND NOT paused
+		RETURNING `+subscriptionColumns, chatID, reason, PauseSourceChatMember)
+}
+
+func (db *DB) GetChatSubscriptions(ctx context.Context, chatID int64) ([]SubscriptionData, error) {
+	return db.querySubscriptions(ctx, `
+		SELECT `+subscriptionColumns+`
+		FROM subscriptions
+		WHERE channel_id = $1
+	`, chatID)
+}
+
+// ResumeSubscriptions снимает с паузы подписки ids, поставленные на паузу
+// источником source, и возвращает их
+func (db *DB) ResumeSubscriptions(ctx context.Context, ids []int, source string) ([]SubscriptionData, error) {
+	return db.querySubscriptions(ctx, `
+		UPDATE subscriptions
+		SET paused = FALSE, pause_reason = '', pause_source = '', failure_count = 0
+		WHERE id = ANY($1) AND paused AND pause_source = $2
+		RETURNING `+subscriptionColumns, ids, source)
+}
+
+func (db *DB) ResumeSubscription(ctx context.Context, id int, userID int64) error {
+	cmdTag, err := db.Pool.Exec(ctx, `
+		UPDATE subscriptions
+		SET paused = FALSE, pause_reason = '', pause_source = '', failure_count = 0
+		WHERE id = $1 AND user_id = $2
+	`, id, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка при включении подписки: %w", err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return fmt.Errorf("подписка %d не найдена", id)
+	}
+	return nil
+}
+
+// RecordSubscriptionFailure учитывает ошибку отправки, после которой
+// подписка продолжает работать
+func (db *DB) RecordSubscriptionFailure(ctx context.Context, id int, lastError string) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE subscriptions
+		SET failure_count = failure_count + 1, last_error = $2
+		WHERE id = $1
+	`, id, lastError)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения ошибки подписки %d: %w", id, err)
+	}
+	return nil
+}
+
+// PauseSubscription учитывает ошибку отправки и ставит подписку на паузу.
+// Возвращает false, если подписка уже была на паузе.
+func (db *DB) PauseSubscription(ctx context.Context, id int, reason, lastError string) (bool, error) {
+	cmdTag, err := db.Pool.Exec(ctx, `
+		UPDATE subscriptions
+		SET paused = TRUE, pause_reason = $2, pause_source = $4, failure_count = failure_count + 1, last_error = $3
+		WHERE id = $1 AND NOT paused
+	`, id, reason, lastError, PauseSourceSendError)
+	if err != nil {
+		return false, fmt.Errorf("ошибка при приостановке подписки %d: %w", id, err)
+	}
+	return cmdTag.RowsAffected() > 0, nil
+}
+
+// ResetSubscriptionFailures сбрасывает счётчик ошибок после удачной отправки
+func (db *DB) ResetSubscriptionFailures(ctx context.Context, id int) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE subscriptions
+		SET failure_count = 0
+		WHERE id = $1 AND failure_count > 0
+	`, id)
+	if err != nil {
+		return fmt.Errorf("ошибка сброса ошибок подписки %d: %w", id, err)
+	}
+	return nil
+}
+
+func (db *DB) querySubscriptions(ctx context.Context, sql string, args ...any) ([]SubscriptionData, error) {
+	rows, err := db.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка запроса подписок: %w", err)
+	}
+	defer rows.Close()
+
+	var result []SubscriptionData
+	for rows.Next() {
+		d, err := scanSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка обновления подписок: %w", err)
+		}
+		result = append(result, d)
+	}
+	return result, rows.Err()
+}
+
+func (db *DB) GetSubscriptionsByTwitchUserID(twitchUserID string) ([]SubscriptionData, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+subscriptionColumns+`
+		FROM subscriptions
+		WHERE twitch_user_id = $1
+	`, twitchUserID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка выборки подписок: %w", err)
+	}
+	defer rows.Close()
+
+	var result []SubscriptionData
+	for rows.Next() {
+		d, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, d)
+	}
+	return result, nil
+}
+
+// GetAllTwitchUserIDs возвращает ID всех стримеров, на которых есть подписки
+func (db *DB) GetAllTwitchUserIDs() ([]string, error) {
+	return db.queryStrings(`SELECT DISTINCT twitch_user_id FROM subscriptions WHERE twitch_user_id <> ''`)
+}
+
+// GetLiveTwitchUserIDs возвращает стримеров, по которым сейчас висит оповещение
+func (db *DB) GetLiveTwitchUserIDs() ([]string, error) {
+	return db.queryStrings(`
+		SELECT DISTINCT s.twitch_user_id
+		FROM subscriptions s
+		JOIN stream_announcements a ON a.subscription_id = s.id
+		WHERE a.live AND s.twitch_user_id <> ''
+	`)
+}
+
+// GetUnresolvedTwitchUsernames возвращает логины подписок, созданных до
+// того, как стал сохраняться ID стримера
+func (db *DB) GetUnresolvedTwitchUsernames() ([]string, error) {
+	return db.queryStrings(`SELECT DISTINCT twitch_username FROM subscriptions WHERE twitch_user_id = ''`)
+}
+
+func (db *DB) queryStrings(sql string, args ...any) ([]string, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var usernames []string
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// SetTwitchUserID сохраняет ID стримера для подписок, где он ещё не известен
+func (db *DB) SetTwitchUserID(username, twitchUserID, displayName string) error {
+	ctx := context.Background()
+	cmdTag, err := db.Pool.Exec(ctx, `
+		UPDATE subscriptions SET twitch_user_id = $2, twitch_display_name = $3
+		WHERE twitch_username = $1 AND twitch_user_id = ''
+	`, username, twitchUserID, displayName)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения ID стримера: %w", err)
+	}
+	if cmdTag.RowsAffected() > 0 {
+		db.subscriptionsChanged()
+	}
+	return nil
+}
+
+// RenameTwitchUser записывает новые логин и отображаемое имя стримера во все
+// его подписки. Возвращает подписки, у которых сменился логин, со старым логином.
+func (db *DB) RenameTwitchUser(twitchUserID, username, displayName string) ([]SubscriptionData, error) {
+	ctx := context.Background()
+	rows, err := db.Pool.Query(ctx, `
+		WITH old AS (
+			SELECT id, twitch_username FROM subscriptions
+			WHERE twitch_user_id = $1
+			  AND (twitch_username <> $2 OR twitch_display_name <> $3)
+			FOR UPDATE
+		)
+		UPDATE subscriptions s
+		SET twitch_username = $2, twitch_display_name = $3
+		FROM old
+		WHERE s.id = old.id
+		RETURNING s.id, s.user_id, old.twitch_username, s.channel_id, s.channel_name
+	`, twitchUserID, username, displayName)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка переименования стримера: %w", err)
+	}
+	defer rows.Close()
+
+	var renamed []SubscriptionData
 	for rows.Next() {
-		var username string
-		if err := rows.Scan(&username); err != nil {
+		d := SubscriptionData{TwitchUserID: twitchUserID}
+		if err := rows.Scan(&d.ID, &d.UserID, &d.TwitchUsername, &d.ChannelID, &d.ChannelName); err != nil {
 			return nil, err
 		}
-		usernames = append(usernames, username)
+		if d.TwitchUsername != username {
+			renamed = append(renamed, d)
+		}
 	}
-	return usernames, nil
+	return renamed, rows.Err()
 }
 
 func (db *DB) GetAllChannelsForUser(username string) ([]int64, error) {
@@ -225,43 +496,84 @@ func (db *DB) IsAdmin(id int) (bool, error) {
 	return false, fmt.Errorf("user not found")
 }
 
-func (db *DB) GetStreamData(username string) (*SubscriptionData, error) {
+// GetStreamAnnouncement возвращает состояние оповещения подписки в канале.
+// Если оповещений ещё не было, возвращается пустое состояние.
+func (db *DB) GetStreamAnnouncement(subscriptionID int, channelID int64) (*StreamAnnouncement, error) {
 	ctx := context.Background()
-	row := db.Pool.QueryRow(ctx, `
-		SELECT user_id, twitch_username, live, checked, latest_message
-		FROM subscriptions
-		WHERE twitch_username = $1
-		LIMIT 1
-	`, username)
-
-	var data SubscriptionData
-	err := row.Scan(&data.UserID, &data.TwitchUsername, &data.Live, &data.Checked, &data.LatestMessageID)
-	if err != nil {
+	data := StreamAnnouncement{SubscriptionID: subscriptionID, ChannelID: channelID}
+	err := db.Pool.QueryRow(ctx, `
+		SELECT message_id, live, checked, is_photo, last_text, edited_at,
+			stream_id, twitch_user_id, title, started_at, peak_viewers, games
+		FROM stream_announcements
+		WHERE subscription_id = $1 AND channel_id = $2
+	`, subscriptionID, channelID).Scan(&data.MessageID, &data.Live, &data.Checked, &data.IsPhoto, &data.LastText, &data.EditedAt,
+		&data.StreamID, &data.TwitchUserID, &data.Title, &data.StartedAt, &data.PeakViewers, &data.Games)
+
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
 		return nil, fmt.Errorf("не удалось получить данные о стриме: %w", err)
 	}
 
 	return &data, nil
 }
 
-func (db *DB) UpdateStreamStatus(username string, live bool, checked bool, latestMessageID int) error {
+func (db *DB) SaveStreamAnnouncement(data StreamAnnouncement) error {
 	ctx := context.Background()
+	if data.Games == nil {
+		data.Games = []string{}
+	}
 	_, err := db.Pool.Exec(ctx, `
-		UPDATE subscriptions
-		SET live = $1, checked = $2, latest_message = $3
-		WHERE twitch_username = $4
-	`, live, checked, latestMessageID, username)
+		INSERT INTO stream_announcements (subscription_id, channel_id, message_id, live, checked, is_photo, last_text, edited_at,
+			stream_id, twitch_user_id, title, started_at, peak_viewers, games, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, NOW())
+		ON CONFLICT (subscription_id, channel_id) DO UPDATE
+		SET message_id = EXCLUDED.message_id,
+			live = EXCLUDED.live,
+			checked = EXCLUDED.checked,
+			is_photo = EXCLUDED.is_photo,
+			last_text = EXCLUDED.last_text,
+			edited_at = EXCLUDED.edited_at,
+			stream_id = EXCLUDED.stream_id,
+			twitch_user_id = EXCLUDED.twitch_user_id,
+			title = EXCLUDED.title,
+			started_at = EXCLUDED.started_at,
+			peak_viewers = EXCLUDED.peak_viewers,
+			games = EXCLUDED.games,
+			updated_at = EXCLUDED.updated_at
+	`, data.SubscriptionID, data.ChannelID, data.MessageID, data.Live, data.Checked, data.IsPhoto, data.LastText, data.EditedAt,
+		data.StreamID, data.TwitchUserID, data.Title, data.StartedAt, data.PeakViewers, data.Games)
 	return err
 }
 
-func (db *DB) MakeUserPro(userID int64) error {
-	expiry := time.Now().Add(proDuration)
+func (db *DB) DeleteStreamAnnouncement(subscriptionID int, channelID int64) error {
+	ctx := context.Background()
+	_, err := db.Pool.Exec(ctx, `
+		DELETE FROM stream_announcements
+		WHERE subscription_id = $1 AND channel_id = $2
+	`, subscriptionID, channelID)
+	return err
+}
 
-	_, err := db.Pool.Exec(context.Background(), `
+// MakeUserPro продлевает Pro на months месяцев. Продление считается от
+// текущей даты окончания, если она ещё не наступила, поэтому оставшиеся дни
+// при досрочном продлении не пропадают.
+func (db *DB) MakeUserPro(userID int64, months int) error {
+	return makeUserPro(context.Background(), db.Pool, userID, months)
+}
+
+// querier — общее у пула и транзакции
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func makeUserPro(ctx context.Context, q querier, userID int64, months int) error {
+	// GREATEST пропускает NULL, так что без активной подписки срок идёт от NOW()
+	_, err := q.Exec(ctx, `
 		INSERT INTO users (telegram_id, expires_at)
-		VALUES ($1, $2)
+		VALUES ($1, NOW() + make_interval(months => $2::int))
 		ON CONFLICT (telegram_id) DO UPDATE
-		SET expires_at = EXCLUDED.expires_at;
-	`, userID, expiry)
+		SET expires_at = GREATEST(users.expires_at, NOW()) + make_interval(months => $2::int);
+	`, userID, months)
 
 	return err
 }
@@ -294,42 +606,29 @@ func (db *DB) IsUserPro(userID int64) (bool, time.Time, error) {
 	return true, expiry, nil
 }
 
-func (db *DB) RemoveExpiredProUsers(bot *tgbotapi.BotAPI) error {
-	rows, err := db.Pool.Query(context.Background(), `
-		SELECT telegram_id FROM users
-		WHERE expires_at IS NOT NULL AND expires_at <= NOW();
+// RemoveExpiredProUsers снимает истёкшую Pro-подписку и возвращает
+// пользователей, которых нужно об этом предупредить
+func (db *DB) RemoveExpiredProUsers(ctx context.Context) ([]int64, error) {
+	rows, err := db.Pool.Query(ctx, `
+		UPDATE users
+		SET expires_at = NULL
+		WHERE expires_at IS NOT NULL AND expires_at <= NOW()
+		RETURNING telegram_id
 	`)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer rows.Close()
 
 	var expiredUserIDs []int64
-
 	for rows.Next() {
 		var userID int64
-		if err := rows.Scan(&userID); err == nil {
-			expiredUserIDs = append(expiredUserIDs, userID)
-		}
-	}
-
-	_, err = db.Pool.Exec(context.Background(), `
-		UPDATE users
-		SET expires_at = NULL
-		WHERE expires_at IS NOT NULL AND expires_at <= NOW();
-	`)
-	if err != nil {
-		return err
-	}
-
-	for _, userID := range expiredUserIDs {
-		msg := tgbotapi.NewMessage(userID, "❌ Ваша подписка Pro истекла.")
-		if _, err := bot.Send(msg); err != nil {
-			log.Printf("Не удалось отправить сообщение %d: %v", userID, err)
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
 		}
+		expiredUserIDs = append(expiredUserIDs, userID)
 	}
-
-	return nil
+	return expiredUserIDs, rows.Err()
 }
 
 func (db *DB) GetUserEmail(telegramID int64) (string, error) {
@@ -367,3 +666,227 @@ func (db *DB) UpdateUserEmail(data UserData) error {
 
 	return nil
 }
+
+// GetConversation возвращает сохранённый диалог пользователя или nil, если его нет
+func (db *DB) GetConversation(ctx context.Context, chatID, userID int64) ([]byte, error) {
+	var data []byte
+	err := db.Pool.QueryRow(ctx, `
+		SELECT data FROM conversations
+		WHERE chat_id = $1 AND user_id = $2
+	`, chatID, userID).Scan(&data)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения диалога: %w", err)
+	}
+	return data, nil
+}
+
+func (db *DB) SaveConversation(ctx context.Context, chatID, userID int64, state string, data []byte) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO conversations (chat_id, user_id, state, data, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (chat_id, user_id) DO UPDATE
+		SET state = EXCLUDED.state,
+			data = EXCLUDED.data,
+			updated_at = EXCLUDED.updated_at
+	`, chatID, userID, state, data)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения диалога: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) DeleteConversation(ctx context.Context, chatID, userID int64) error {
+	_, err := db.Pool.Exec(ctx, `
+		DELETE FROM conversations
+		WHERE chat_id = $1 AND user_id = $2
+	`, chatID, userID)
+	return err
+}
+
+// GetTwitchToken возвращает сохранённый токен Twitch или пустую строку, если его нет
+func (db *DB) GetTwitchToken(ctx context.Context, clientID string) (string, time.Time, error) {
+	var token string
+	var expiresAt time.Time
+	err := db.Pool.QueryRow(ctx, `
+		SELECT access_token, expires_at FROM twitch_tokens WHERE client_id = $1
+	`, clientID).Scan(&token, &expiresAt)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", time.Time{}, nil
+		}
+		return "", time.Time{}, fmt.Errorf("ошибка получения токена Twitch: %w", err)
+	}
+	return token, expiresAt, nil
+}
+
+func (db *DB) SaveTwitchToken(ctx context.Context, clientID, token string, expiresAt time.Time) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO twitch_tokens (client_id, access_token, expires_at, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (client_id) DO UPDATE
+		SET access_token = EXCLUDED.access_token,
+			expires_at = EXCLUDED.expires_at,
+			updated_at = EXCLUDED.updated_at
+	`, clientID, token, expiresAt)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения токена Twitch: %w", err)
+	}
+	return nil
+}
+
+// CreatePayment сохраняет созданный платёж. Повторная вставка того же ID не
+// меняет запись.
+func (db *DB) CreatePayment(ctx context.Context, p Payment) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO payments (id, user_id, amount, currency, months, status, idempotence_key, payload)
+		VALUES ($1, $2, $3::text::numeric, $4, $5, $6, NULLIF($7, ''), $8)
+		ON CONFLICT (id) DO NOTHING
+	`, p.ID, p.UserID, p.Amount, p.Currency, p.Months, p.Status, p.IdempotenceKey, jsonPayload(p.Payload))
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения платежа %s: %w", p.ID, err)
+	}
+	return nil
+}
+
+// CompletePayment переводит платёж в succeeded и продлевает Pro на p.Months
+// в одной транзакции. Возвращает false, если платёж уже был обработан — повторные
+// уведомления YooKassa ничего не меняют.
+func (db *DB) CompletePayment(ctx context.Context, p Payment) (bool, error) {
+	p.Status = PaymentSucceeded
+	var applied bool
+	err := pgx.BeginFunc(ctx, db.Pool, func(tx pgx.Tx) error {
+		var err error
+		applied, err = setPaymentStatus(ctx, tx, p)
+		if err != nil || !applied {
+			return err
+		}
+		return makeUserPro(ctx, tx, p.UserID, p.Months)
+	})
+	if err != nil {
+		return false, fmt.Errorf("ошибка проведения платежа %s: %w", p.ID, err)
+	}
+	return applied, nil
+}
+
+// CancelPayment отмечает платёж отменённым, если он ещё не завершён
+func (db *DB) CancelPayment(ctx context.Context, p Payment) (bool, error) {
+	p.Status = PaymentCanceled
+	applied, err := setPaymentStatus(ctx, db.Pool, p)
+	if err != nil {
+		return false, fmt.Errorf("ошибка отмены платежа %s: %w", p.ID, err)
+	}
+	return applied, nil
+}
+
+// setPaymentStatus меняет статус, только пока платёж не в конечном статусе.
+// Платёж, созданный до появления таблицы, добавляется сразу с новым статусом.
+func setPaymentStatus(ctx context.Context, q querier, p Payment) (bool, error) {
+	var id string
+	err := q.QueryRow(ctx, `
+		INSERT INTO payments (id, user_id, amount, currency, months, status, payload)
+		VALUES ($1, $2, $3::text::numeric, $4, $5, $6, $7)
+		ON CONFLICT (id) DO UPDATE
+		SET status = EXCLUDED.status,
+			payload = EXCLUDED.payload,
+			updated_at = NOW()
+		WHERE payments.status NOT IN ('succeeded', 'canceled')
+		RETURNING id
+	`, p.ID, p.UserID, p.Amount, p.Currency, p.Months, p.Status, jsonPayload(p.Payload)).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetPayment возвращает сохранённый платёж или nil, если записи нет
+func (db *DB) GetPayment(ctx context.Context, id string) (*Payment, error) {
+	var p Payment
+	err := db.Pool.QueryRow(ctx, `
+		SELECT id, user_id, amount::text, currency, months, status, created_at, updated_at
+		FROM payments
+		WHERE id = $1
+	`, id).Scan(&p.ID, &p.UserID, &p.Amount, &p.Currency, &p.Months, &p.Status, &p.CreatedAt, &p.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения платежа %s: %w", id, err)
+	}
+	return &p, nil
+}
+
+// GetUserPayments возвращает последние limit платежей пользователя, новые первыми
+func (db *DB) GetUserPayments(ctx context.Context, userID int64, limit int) ([]Payment, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, user_id, amount::text, currency, months, status, created_at, updated_at
+		FROM payments
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения платежей: %w", err)
+	}
+	defer rows.Close()
+
+	var payments []Payment
+	for rows.Next() {
+		var p Payment
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Amount, &p.Currency, &p.Months, &p.Status, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка получения платежей: %w", err)
+		}
+		payments = append(payments, p)
+	}
+	return payments, rows.Err()
+}
+
+func jsonPayload(payload []byte) string {
+	if len(payload) == 0 {
+		return "{}"
+	}
+	return string(payload)
+}
+
+// SaveChatTarget добавляет чат в список доступных пользователю или обновляет
+// его название
+func (db *DB) SaveChatTarget(ctx context.Context, t ChatTarget) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO chat_targets (user_id, chat_id, thread_id, chat_type, title)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, chat_id, thread_id) DO UPDATE
+		SET chat_type = EXCLUDED.chat_type,
+			title = EXCLUDED.title
+	`, t.UserID, t.ChatID, t.ThreadID, t.ChatType, t.Title)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения чата %d: %w", t.ChatID, err)
+	}
+	return nil
+}
+
+func (db *DB) GetUserChatTargets(ctx context.Context, userID int64) ([]ChatTarget, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT user_id, chat_id, thread_id, chat_type, title
+		FROM chat_targets
+		WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения чатов пользователя: %w", err)
+	}
+	defer rows.Close()
+
+	var targets []ChatTarget
+	for rows.Next() {
+		var t ChatTarget
+		if err := rows.Scan(&t.UserID, &t.ChatID, &t.ThreadID, &t.ChatType, &t.Title); err != nil {
+			return nil, fmt.Errorf("ошибка получения чатов пользователя: %w", err)
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS users (
+	telegram_id BIGINT PRIMARY KEY,
+	telegram_username TEXT,
+	pro BOOLEAN DEFAULT FALSE,
+	admin BOOLEAN DEFAULT FALSE
+);
+
+-- Колонки, которые раньше добавлялись в базу вручную
+ALTER TABLE users ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email TEXT;
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+	id SERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(telegram_id) ON DELETE CASCADE,
+	channel_id BIGINT NOT NULL,
+	twitch_username TEXT NOT NULL,
+	latest_message BIGINT NOT NULL DEFAULT 0,
+	UNIQUE(user_id, channel_id, twitch_username)
+);
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS channel_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS live BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS checked BOOLEAN NOT NULL DEFAULT FALSE;
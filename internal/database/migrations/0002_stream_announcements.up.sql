@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS stream_announcements (
+	subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+	channel_id BIGINT NOT NULL,
+	message_id BIGINT NOT NULL DEFAULT 0,
+	live BOOLEAN NOT NULL DEFAULT FALSE,
+	checked BOOLEAN NOT NULL DEFAULT FALSE,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (subscription_id, channel_id)
+);
+
+-- Переносим оповещения, отправленные до появления таблицы
+INSERT INTO stream_announcements (subscription_id, channel_id, message_id, live, checked)
+SELECT id, channel_id, latest_message, live, checked
+FROM subscriptions
+WHERE live AND latest_message <> 0
+ON CONFLICT DO NOTHING;
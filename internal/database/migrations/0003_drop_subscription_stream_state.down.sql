@@ -0,0 +1,4 @@
+ALTER TABLE subscriptions
+	ADD COLUMN IF NOT EXISTS latest_message BIGINT NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS live BOOLEAN NOT NULL DEFAULT FALSE,
+	ADD COLUMN IF NOT EXISTS checked BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,5 @@
+-- Состояние стрима теперь хранится в stream_announcements
+ALTER TABLE subscriptions
+	DROP COLUMN IF EXISTS latest_message,
+	DROP COLUMN IF EXISTS live,
+	DROP COLUMN IF EXISTS checked;
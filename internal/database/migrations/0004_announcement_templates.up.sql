@@ -0,0 +1 @@
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS announcement_template TEXT NOT NULL DEFAULT '';
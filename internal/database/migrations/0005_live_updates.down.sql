@@ -0,0 +1,5 @@
+ALTER TABLE stream_announcements
+	DROP COLUMN IF EXISTS last_text,
+	DROP COLUMN IF EXISTS edited_at;
+
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS live_updates;
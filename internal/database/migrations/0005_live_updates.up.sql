@@ -0,0 +1,5 @@
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS live_updates BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE stream_announcements
+	ADD COLUMN IF NOT EXISTS last_text TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
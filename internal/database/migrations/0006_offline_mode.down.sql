@@ -0,0 +1,9 @@
+ALTER TABLE stream_announcements
+	DROP COLUMN IF EXISTS stream_id,
+	DROP COLUMN IF EXISTS twitch_user_id,
+	DROP COLUMN IF EXISTS title,
+	DROP COLUMN IF EXISTS started_at,
+	DROP COLUMN IF EXISTS peak_viewers,
+	DROP COLUMN IF EXISTS games;
+
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS offline_mode;
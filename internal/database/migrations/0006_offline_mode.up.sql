@@ -0,0 +1,11 @@
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS offline_mode TEXT NOT NULL DEFAULT 'delete'
+	CHECK (offline_mode IN ('delete', 'summary', 'keep', 'separate'));
+
+-- Данные для итогов стрима
+ALTER TABLE stream_announcements
+	ADD COLUMN IF NOT EXISTS stream_id TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS twitch_user_id TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS title TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	ADD COLUMN IF NOT EXISTS peak_viewers INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS games TEXT[] NOT NULL DEFAULT '{}';
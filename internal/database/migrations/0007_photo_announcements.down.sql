@@ -0,0 +1,2 @@
+ALTER TABLE stream_announcements DROP COLUMN IF EXISTS is_photo;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS photo_mode;
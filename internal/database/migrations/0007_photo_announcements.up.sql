@@ -0,0 +1,2 @@
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS photo_mode BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE stream_announcements ADD COLUMN IF NOT EXISTS is_photo BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS conversations (
+	chat_id BIGINT NOT NULL,
+	user_id BIGINT NOT NULL,
+	state TEXT NOT NULL,
+	data JSONB NOT NULL DEFAULT '{}',
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (chat_id, user_id)
+);
@@ -0,0 +1,3 @@
+-- Неизменяемый ID стримера в Twitch; у подписок, созданных до проверки
+-- логина через helix/users, он пустой
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS twitch_user_id TEXT NOT NULL DEFAULT '';
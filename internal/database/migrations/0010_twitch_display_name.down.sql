@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS subscriptions_twitch_user_id_idx;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS twitch_display_name;
@@ -0,0 +1,2 @@
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS twitch_display_name TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS subscriptions_twitch_user_id_idx ON subscriptions (twitch_user_id);
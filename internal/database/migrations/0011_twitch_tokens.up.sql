@@ -0,0 +1,7 @@
+-- App access token Twitch, общий для всех экземпляров бота с одним client_id
+CREATE TABLE IF NOT EXISTS twitch_tokens (
+    client_id TEXT PRIMARY KEY,
+    access_token TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
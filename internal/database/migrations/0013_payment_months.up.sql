@@ -0,0 +1,2 @@
+-- Срок оплаченного тарифа Pro в месяцах
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS months INT NOT NULL DEFAULT 1;
@@ -0,0 +1,3 @@
+-- Ключ идемпотентности, с которым платёж создан в YooKassa
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS idempotence_key TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS payments_idempotence_key_idx ON payments (idempotence_key);
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS chat_targets;
+
+ALTER TABLE subscriptions DROP CONSTRAINT IF EXISTS subscriptions_user_id_channel_id_thread_username_key;
+ALTER TABLE subscriptions ADD CONSTRAINT subscriptions_user_id_channel_id_twitch_username_key
+    UNIQUE (user_id, channel_id, twitch_username);
+
+UPDATE subscriptions SET channel_name = ltrim(channel_name, '@');
+
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS message_thread_id;
@@ -0,0 +1,25 @@
+-- Оповещения можно отправлять в тему форума
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS message_thread_id INT NOT NULL DEFAULT 0;
+
+-- channel_name теперь хранит название чата; у старых подписок там был @username
+UPDATE subscriptions SET channel_name = '@' || channel_name
+WHERE channel_name <> '' AND channel_name NOT LIKE '@%';
+
+-- Один стример может быть подключён к разным темам одной группы
+ALTER TABLE subscriptions DROP CONSTRAINT IF EXISTS subscriptions_user_id_channel_id_twitch_username_key;
+ALTER TABLE subscriptions ADD CONSTRAINT subscriptions_user_id_channel_id_thread_username_key
+    UNIQUE (user_id, channel_id, message_thread_id, twitch_username);
+
+-- Чаты, куда пользователь может отправлять оповещения: каналы, группы и темы
+-- форумов. Добавляются, когда пользователь добавляет бота в чат или вызывает /bind.
+CREATE TABLE IF NOT EXISTS chat_targets (
+    user_id BIGINT NOT NULL,
+    chat_id BIGINT NOT NULL,
+    thread_id INT NOT NULL DEFAULT 0,
+    chat_type TEXT NOT NULL,
+    title TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, chat_id, thread_id)
+);
+
+CREATE INDEX IF NOT EXISTS chat_targets_chat_id_idx ON chat_targets (chat_id);
@@ -0,0 +1,2 @@
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS pause_reason;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS paused;
@@ -0,0 +1,3 @@
+-- Подписка ставится на паузу, когда бота удалили из чата или лишили прав
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS paused BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS pause_reason TEXT NOT NULL DEFAULT '';
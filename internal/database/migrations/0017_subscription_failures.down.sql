@@ -0,0 +1,2 @@
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS last_error;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS failure_count;
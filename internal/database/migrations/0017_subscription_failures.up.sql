@@ -0,0 +1,3 @@
+-- Ошибки отправки оповещений: счётчик подряд идущих ошибок и текст последней
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS failure_count INT NOT NULL DEFAULT 0;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS last_error TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS pause_source;
@@ -0,0 +1,9 @@
+-- Кто поставил подписку на паузу: изменение прав бота в чате или ошибка
+-- отправки. Сами подписки включаются только после паузы из-за прав.
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS pause_source TEXT NOT NULL DEFAULT '';
+
+-- До этой миграции ошибки отправки записывались в last_error, а пауза из-за
+-- прав его не меняла
+UPDATE subscriptions
+SET pause_source = CASE WHEN last_error <> '' THEN 'send_error' ELSE 'chat_member' END
+WHERE paused;
@@ -0,0 +1,157 @@
+package eventsub
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"twitchannouncer/internal/config"
+	"twitchannouncer/internal/database"
+	"twitchannouncer/internal/twitch"
+)
+
+const (
+	TypeStreamOnline  = "stream.online"
+	TypeStreamOffline = "stream.offline"
+)
+
+var streamTypes = []string{TypeStreamOnline, TypeStreamOffline}
+
+// Manager создаёт и отзывает EventSub подписки так, чтобы они совпадали
+// со стримерами из таблицы subscriptions
+type Manager struct {
+	cfg     config.Config
+	db      *database.DB
+	twitch  twitch.Client
+	changed chan struct{}
+}
+
+func NewManager(cfg config.Config, db *database.DB, twitchClient twitch.Client) *Manager {
+	return &Manager{
+		cfg:     cfg,
+		db:      db,
+		twitch:  twitchClient,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// Start синхронизирует подписки сразу, затем периодически и после каждого Trigger
+func (m *Manager) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := m.Sync(ctx); err != nil {
+				log.Printf("Ошибка синхронизации EventSub подписок: %v", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-m.changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Trigger просит выполнить синхронизацию, не дожидаясь тикера
+func (m *Manager) Trigger() {
+	select {
+	case m.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Manager) Sync(ctx context.Context) error {
+	// Подписки без ID стримера появятся здесь после того, как монитор
+	// найдёт их ID по логину
+	userIDs, err := m.db.GetAllTwitchUserIDs()
+	if err != nil {
+		return fmt.Errorf("ошибка получения твич-юзеров: %w", err)
+	}
+	wanted := make(map[string]bool, len(userIDs))
+	for _, id := range userIDs {
+		wanted[id] = true
+	}
+
+	existing, err := m.listSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	have := make(map[string]bool)
+	for _, sub := range existing {
+		broadcasterID := sub.Condition["broadcaster_user_id"]
+		key := sub.Type + ":" + broadcasterID
+		active := sub.Status == "enabled" || sub.Status == "webhook_callback_verification_pending"
+		if wanted[broadcasterID] && active && !have[key] {
+			have[key] = true
+			continue
+		}
+
+		if err := m.twitch.DeleteEventSubSubscription(ctx, sub.ID); err != nil {
+			log.Printf("Не удалось отозвать EventSub подписку %s: %v", sub.ID, err)
+			continue
+		}
+		log.Printf("Отозвана EventSub подписка %s на %s (%s)", sub.Type, broadcasterID, sub.Status)
+	}
+
+	for id := range wanted {
+		for _, eventType := range streamTypes {
+			if have[eventType+":"+id] {
+				continue
+			}
+			if err := m.createSubscription(ctx, eventType, id); err != nil {
+				log.Printf("Не удалось создать EventSub подписку %s на %s: %v", eventType, id, err)
+				continue
+			}
+			log.Printf("Создана EventSub подписка %s на %s", eventType, id)
+		}
+	}
+
+	return nil
+}
+
+// listSubscriptions возвращает наши подписки на события стримов
+func (m *Manager) listSubscriptions(ctx context.Context) ([]twitch.EventSubSubscription, error) {
+	all, err := m.twitch.GetEventSubSubscriptions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения EventSub подписок: %w", err)
+	}
+
+	var subs []twitch.EventSubSubscription
+	for _, sub := range all {
+		if sub.Transport.Callback != m.cfg.EventSubCallbackURL {
+			continue
+		}
+		if sub.Type != TypeStreamOnline && sub.Type != TypeStreamOffline {
+			continue
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+func (m *Manager) createSubscription(ctx context.Context, eventType, broadcasterID string) error {
+	err := m.twitch.CreateEventSubSubscription(ctx, twitch.EventSubSubscription{
+		Type:    eventType,
+		Version: "1",
+		Condition: map[string]string{
+			"broadcaster_user_id": broadcasterID,
+		},
+		Transport: twitch.EventSubTransport{
+			Method:   "webhook",
+			Callback: m.cfg.EventSubCallbackURL,
+			Secret:   m.cfg.EventSubSecret,
+		},
+	})
+	if twitch.IsStatus(err, http.StatusConflict) {
+		// Подписка уже существует
+		return nil
+	}
+	return err
+}
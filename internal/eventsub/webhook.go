@@ -0,0 +1,168 @@
+package eventsub
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	headerMessageID        = "Twitch-Eventsub-Message-Id"
+	headerMessageTimestamp = "Twitch-Eventsub-Message-Timestamp"
+	headerMessageSignature = "Twitch-Eventsub-Message-Signature"
+	headerMessageType      = "Twitch-Eventsub-Message-Type"
+
+	messageTypeVerification = "webhook_callback_verification"
+	messageTypeNotification = "notification"
+	messageTypeRevocation   = "revocation"
+
+	// Twitch рекомендует отбрасывать сообщения старше 10 минут
+	maxMessageAge = 10 * time.Minute
+)
+
+// StreamHandler получает события о начале и окончании стримов по ID стримера
+type StreamHandler interface {
+	StreamOnline(twitchUserID string)
+	StreamOffline(twitchUserID string)
+}
+
+type Notification struct {
+	Challenge    string `json:"challenge"`
+	Subscription struct {
+		ID     string `json:"id"`
+		Type   string `json:"type"`
+		Status string `json:"status"`
+	} `json:"subscription"`
+	Event struct {
+		BroadcasterUserID    string `json:"broadcaster_user_id"`
+		BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	} `json:"event"`
+}
+
+func HandleWebhook(secret string, handler StreamHandler) http.HandlerFunc {
+	seen := newMessageCache(maxMessageAge)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "can't read body", http.StatusBadRequest)
+			log.Printf("Ошибка чтения тела запроса EventSub: %v", err)
+			return
+		}
+
+		messageID := r.Header.Get(headerMessageID)
+		timestamp := r.Header.Get(headerMessageTimestamp)
+		if !verifySignature(secret, messageID, timestamp, body, r.Header.Get(headerMessageSignature)) {
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			log.Printf("Неверная подпись EventSub сообщения %s", messageID)
+			return
+		}
+
+		sentAt, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil || time.Since(sentAt) > maxMessageAge {
+			http.Error(w, "stale message", http.StatusBadRequest)
+			log.Printf("Устаревшее EventSub сообщение %s", messageID)
+			return
+		}
+
+		var notif Notification
+		if err := json.Unmarshal(body, &notif); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			log.Printf("Ошибка декодирования EventSub JSON: %v", err)
+			return
+		}
+
+		// Twitch повторяет доставку, пока не получит 2xx, поэтому дубликаты
+		// подтверждаем, но не обрабатываем. Сообщение запоминаем только после
+		// разбора, чтобы повтор неразобранного не потерялся.
+		if !seen.add(messageID) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		switch r.Header.Get(headerMessageType) {
+		case messageTypeVerification:
+			log.Printf("Подтверждена EventSub подписка %s (%s)", notif.Subscription.ID, notif.Subscription.Type)
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(notif.Challenge))
+			return
+
+		case messageTypeNotification:
+			// Логин стримера может смениться, поэтому подписки ищутся по ID.
+			// Ответить Twitch нужно за несколько секунд, поэтому
+			// оповещения отправляются в фоне
+			userID := notif.Event.BroadcasterUserID
+			switch notif.Subscription.Type {
+			case TypeStreamOnline:
+				go handler.StreamOnline(userID)
+			case TypeStreamOffline:
+				go handler.StreamOffline(userID)
+			default:
+				log.Printf("Необработанное EventSub событие: %s", notif.Subscription.Type)
+			}
+
+		case messageTypeRevocation:
+			log.Printf("Twitch отозвал EventSub подписку %s (%s): %s",
+				notif.Subscription.ID, notif.Subscription.Type, notif.Subscription.Status)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func verifySignature(secret, messageID, timestamp string, body []byte, signature string) bool {
+	if messageID == "" || timestamp == "" || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(messageID))
+	mac.Write([]byte(timestamp))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// messageCache хранит ID обработанных сообщений, пока их ещё могут прислать повторно
+type messageCache struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	seen map[string]time.Time
+}
+
+func newMessageCache(ttl time.Duration) *messageCache {
+	return &messageCache{
+		ttl:  ttl,
+		seen: make(map[string]time.Time),
+	}
+}
+
+// add возвращает false, если сообщение уже встречалось
+func (c *messageCache) add(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for seenID, at := range c.seen {
+		if now.Sub(at) > c.ttl {
+			delete(c.seen, seenID)
+		}
+	}
+
+	if _, ok := c.seen[id]; ok {
+		return false
+	}
+	c.seen[id] = now
+	return true
+}
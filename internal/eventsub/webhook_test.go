@@ -0,0 +1,162 @@
+package eventsub_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"twitchannouncer/internal/eventsub"
+)
+
+const secret = "0123456789abcdef"
+
+const onlineBody = `{"subscription":{"id":"sub-1","type":"stream.online","status":"enabled"},"event":{"broadcaster_user_id":"1005","broadcaster_user_login":"streamer"}}`
+
+// streamHandler записывает события, которые webhook передал монитору
+type streamHandler struct {
+	online  chan string
+	offline chan string
+}
+
+func newStreamHandler() *streamHandler {
+	return &streamHandler{online: make(chan string, 10), offline: make(chan string, 10)}
+}
+
+func (h *streamHandler) StreamOnline(twitchUserID string)  { h.online <- twitchUserID }
+func (h *streamHandler) StreamOffline(twitchUserID string) { h.offline <- twitchUserID }
+
+type message struct {
+	id        string
+	timestamp time.Time
+	kind      string
+	body      string
+	signature string
+}
+
+func (m message) send(t *testing.T, url string) (int, string) {
+	t.Helper()
+	timestamp := m.timestamp.UTC().Format(time.RFC3339Nano)
+	signature := m.signature
+	if signature == "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(m.id + timestamp + m.body))
+		signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(m.body))
+	require.NoError(t, err)
+	req.Header.Set("Twitch-Eventsub-Message-Id", m.id)
+	req.Header.Set("Twitch-Eventsub-Message-Timestamp", timestamp)
+	req.Header.Set("Twitch-Eventsub-Message-Signature", signature)
+	req.Header.Set("Twitch-Eventsub-Message-Type", m.kind)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func newServer(handler eventsub.StreamHandler) *httptest.Server {
+	return httptest.NewServer(eventsub.HandleWebhook(secret, handler))
+}
+
+func TestWebhookDispatchesSignedNotification(t *testing.T) {
+	handler := newStreamHandler()
+	server := newServer(handler)
+	defer server.Close()
+
+	status, _ := message{id: "msg-1", timestamp: time.Now(), kind: "notification", body: onlineBody}.send(t, server.URL)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	select {
+	case id := <-handler.online:
+		assert.Equal(t, "1005", id)
+	case <-time.After(time.Second):
+		t.Fatal("событие stream.online не передано")
+	}
+}
+
+func TestWebhookRejectsBadSignature(t *testing.T) {
+	handler := newStreamHandler()
+	server := newServer(handler)
+	defer server.Close()
+
+	for name, signature := range map[string]string{
+		"чужой ключ":   "sha256=" + strings.Repeat("0", 64),
+		"без префикса": strings.Repeat("0", 64),
+		"не hex":       "sha256=zz",
+	} {
+		status, _ := message{id: "msg-" + name, timestamp: time.Now(), kind: "notification", body: onlineBody, signature: signature}.send(t, server.URL)
+		assert.Equal(t, http.StatusForbidden, status, name)
+	}
+	assert.Empty(t, handler.online)
+}
+
+func TestWebhookRejectsStaleMessage(t *testing.T) {
+	handler := newStreamHandler()
+	server := newServer(handler)
+	defer server.Close()
+
+	stale := message{id: "msg-old", timestamp: time.Now().Add(-11 * time.Minute), kind: "notification", body: onlineBody}
+	status, _ := stale.send(t, server.URL)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Empty(t, handler.online)
+}
+
+func TestWebhookAcknowledgesDuplicateOnce(t *testing.T) {
+	handler := newStreamHandler()
+	server := newServer(handler)
+	defer server.Close()
+
+	msg := message{id: "msg-dup", timestamp: time.Now(), kind: "notification", body: onlineBody}
+	status, _ := msg.send(t, server.URL)
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = msg.send(t, server.URL)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	<-handler.online
+	select {
+	case <-handler.online:
+		t.Fatal("повторная доставка обработана дважды")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebhookRetryAfterInvalidJSONIsProcessed(t *testing.T) {
+	handler := newStreamHandler()
+	server := newServer(handler)
+	defer server.Close()
+
+	// Неразобранное сообщение не запоминается, повтор с тем же ID обрабатывается
+	status, _ := message{id: "msg-retry", timestamp: time.Now(), kind: "notification", body: "{"}.send(t, server.URL)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = message{id: "msg-retry", timestamp: time.Now(), kind: "notification", body: onlineBody}.send(t, server.URL)
+	assert.Equal(t, http.StatusNoContent, status)
+	select {
+	case <-handler.online:
+	case <-time.After(time.Second):
+		t.Fatal("повтор после ошибки разбора не обработан")
+	}
+}
+
+func TestWebhookEchoesVerificationChallenge(t *testing.T) {
+	server := newServer(newStreamHandler())
+	defer server.Close()
+
+	body := `{"challenge":"pogchamp-kappa-360noscope-vohiyo","subscription":{"id":"sub-1","type":"stream.online","status":"webhook_callback_verification_pending"}}`
+	status, got := message{id: "msg-verify", timestamp: time.Now(), kind: "webhook_callback_verification", body: body}.send(t, server.URL)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "pogchamp-kappa-360noscope-vohiyo", got)
+}
@@ -0,0 +1,406 @@
+// Package twitch — клиент Twitch Helix API и получения app access token
+package twitch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHelixURL = "https://api.twitch.tv/helix"
+	DefaultAuthURL  = "https://id.twitch.tv/oauth2/token"
+
+	// Максимальное количество id или login в одном запросе к Helix
+	MaxIDsPerRequest = 100
+
+	// Сколько раз повторять запрос после 401 или 429
+	maxAttempts = 3
+	// Токен обновляется заранее, чтобы он не истёк посреди запроса
+	tokenExpiryMargin = time.Minute
+	// Пауза перед повторной попыткой получить токен после ошибки
+	tokenRetryDelay = time.Minute
+)
+
+// Client — методы Twitch API, которыми пользуется бот
+type Client interface {
+	GetStreams(ctx context.Context, query StreamsQuery) ([]Stream, error)
+	GetUsers(ctx context.Context, query UsersQuery) ([]User, error)
+	GetVideos(ctx context.Context, query VideosQuery) ([]Video, error)
+	GetGames(ctx context.Context, ids []string) ([]Game, error)
+	GetChannels(ctx context.Context, broadcasterIDs []string) ([]Channel, error)
+
+	GetEventSubSubscriptions(ctx context.Context) ([]EventSubSubscription, error)
+	CreateEventSubSubscription(ctx context.Context, sub EventSubSubscription) error
+	DeleteEventSubSubscription(ctx context.Context, id string) error
+
+	// Token возвращает действующий токен, при необходимости получая новый
+	Token(ctx context.Context) (Token, error)
+	RefreshToken(ctx context.Context) (Token, error)
+}
+
+type Token struct {
+	AccessToken string
+	ExpiresAt   time.Time
+}
+
+func (t Token) Valid(now time.Time) bool {
+	return t.AccessToken != "" && now.Before(t.ExpiresAt.Add(-tokenExpiryMargin))
+}
+
+type Options struct {
+	ClientID     string
+	ClientSecret string
+	// HelixURL и AuthURL по умолчанию указывают на api.twitch.tv и id.twitch.tv
+	HelixURL   string
+	AuthURL    string
+	HTTPClient *http.Client
+	// Store хранит токен между перезапусками; по умолчанию токен хранится в памяти
+	Store TokenStore
+}
+
+// APIError — ответ Twitch API с кодом 4xx или 5xx
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("ошибка от Twitch API [%d]: %s", e.StatusCode, e.Message)
+}
+
+// IsStatus сообщает, что err — ответ Twitch API с кодом status
+func IsStatus(err error, status int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+type client struct {
+	opts Options
+	http *http.Client
+
+	tokenMu sync.Mutex
+	token   Token
+
+	// Состояние лимита запросов по заголовкам Ratelimit-Remaining и Ratelimit-Reset
+	limitMu   sync.Mutex
+	remaining int
+	reset     time.Time
+}
+
+func NewClient(opts Options) Client {
+	if opts.HelixURL == "" {
+		opts.HelixURL = DefaultHelixURL
+	}
+	if opts.AuthURL == "" {
+		opts.AuthURL = DefaultAuthURL
+	}
+	if opts.Store == nil {
+		opts.Store = NewMemoryTokenStore()
+	}
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &client{
+		opts:      opts,
+		http:      httpClient,
+		remaining: -1,
+	}
+}
+
+func (c *client) Token(ctx context.Context) (Token, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	if c.token.Valid(time.Now()) {
+		return c.token, nil
+	}
+
+	// Токен мог получить другой экземпляр бота или предыдущий запуск
+	stored, err := c.opts.Store.Load(ctx)
+	if err != nil {
+		log.Printf("Ошибка загрузки токена Twitch: %v", err)
+	} else if stored.Valid(time.Now()) {
+		c.token = stored
+		return c.token, nil
+	}
+
+	return c.refreshLocked(ctx)
+}
+
+func (c *client) RefreshToken(ctx context.Context) (Token, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	return c.refreshLocked(ctx)
+}
+
+// tokenRejected вызывается после ответа 401. Если токен уже обновил другой
+// запрос, второй раз его не обновляем.
+func (c *client) tokenRejected(ctx context.Context, rejected Token) error {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	if c.token.AccessToken != rejected.AccessToken && c.token.Valid(time.Now()) {
+		return nil
+	}
+	_, err := c.refreshLocked(ctx)
+	return err
+}
+
+// refreshLocked получает новый токен; вызывается под tokenMu
+func (c *client) refreshLocked(ctx context.Context) (Token, error) {
+	form := url.Values{}
+	form.Set("client_id", c.opts.ClientID)
+	form.Set("client_secret", c.opts.ClientSecret)
+	form.Set("grant_type", "client_credentials")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.opts.AuthURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Token{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return Token{}, fmt.Errorf("ошибка получения токена: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return Token{}, fmt.Errorf("ошибка получения токена: %w", &APIError{StatusCode: resp.StatusCode, Message: string(body)})
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Token{}, fmt.Errorf("ошибка разбора ответа: %w", err)
+	}
+	if result.AccessToken == "" {
+		return Token{}, fmt.Errorf("Twitch не вернул токен")
+	}
+
+	c.token = Token{
+		AccessToken: result.AccessToken,
+		ExpiresAt:   time.Now().Add(time.Duration(result.ExpiresIn) * time.Second),
+	}
+	// Токен уже получен, поэтому ошибка сохранения не мешает работе
+	if err := c.opts.Store.Save(ctx, c.token); err != nil {
+		log.Printf("Ошибка сохранения токена Twitch: %v", err)
+	}
+	log.Printf("Токен Twitch обновлён, действует до %s", c.token.ExpiresAt.Format("02.01.2006 15:04"))
+	return c.token, nil
+}
+
+// KeepTokenFresh обновляет токен незадолго до истечения срока из expires_in,
+// чтобы запросы к Helix не ждали получения нового токена. Работает до отмены ctx.
+func KeepTokenFresh(ctx context.Context, c Client) {
+	for {
+		wait := tokenRetryDelay
+		token, err := c.Token(ctx)
+		if err != nil {
+			log.Printf("Не удалось обновить Twitch токен: %v", err)
+		} else {
+			wait = max(time.Until(token.ExpiresAt.Add(-tokenExpiryMargin)), time.Second)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// waitRateLimit ждёт сброса лимита, если запросы в текущем окне закончились
+func (c *client) waitRateLimit(ctx context.Context) error {
+	c.limitMu.Lock()
+	wait := time.Duration(0)
+	if c.remaining == 0 {
+		wait = time.Until(c.reset)
+	}
+	c.limitMu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		c.limitMu.Lock()
+		c.remaining = -1
+		c.limitMu.Unlock()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *client) updateRateLimit(header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("Ratelimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(header.Get("Ratelimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	c.limitMu.Lock()
+	c.remaining = remaining
+	c.reset = time.Unix(reset, 0)
+	c.limitMu.Unlock()
+}
+
+// limitExceeded отмечает, что лимит исчерпан, даже если Twitch не прислал
+// заголовки Ratelimit-*
+func (c *client) limitExceeded() {
+	c.limitMu.Lock()
+	defer c.limitMu.Unlock()
+	c.remaining = 0
+	if time.Until(c.reset) <= 0 {
+		c.reset = time.Now().Add(time.Second)
+	}
+}
+
+// do выполняет запрос к Helix. При 401 токен обновляется, при 429 запрос
+// повторяется после сброса лимита.
+func (c *client) do(ctx context.Context, method, path string, params url.Values, body, out any) error {
+	endpoint := c.opts.HelixURL + path
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	refreshed := false
+	for attempt := 1; ; attempt++ {
+		if err := c.waitRateLimit(ctx); err != nil {
+			return err
+		}
+
+		token, err := c.Token(ctx)
+		if err != nil {
+			return err
+		}
+
+		var reqBody io.Reader
+		if payload != nil {
+			reqBody = bytes.NewReader(payload)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Client-ID", c.opts.ClientID)
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+		c.updateRateLimit(resp.Header)
+
+		if resp.StatusCode >= 400 {
+			respBody, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			apiErr := &APIError{StatusCode: resp.StatusCode, Message: string(respBody)}
+
+			switch {
+			case resp.StatusCode == http.StatusUnauthorized && !refreshed:
+				refreshed = true
+				if err := c.tokenRejected(ctx, token); err != nil {
+					return err
+				}
+				continue
+			case resp.StatusCode == http.StatusTooManyRequests && attempt < maxAttempts:
+				c.limitExceeded()
+				continue
+			}
+			return apiErr
+		}
+
+		err = nil
+		if out != nil && resp.StatusCode != http.StatusNoContent {
+			if decodeErr := json.NewDecoder(resp.Body).Decode(out); decodeErr != nil {
+				err = fmt.Errorf("ошибка при декодировании ответа Twitch API: %w", decodeErr)
+			}
+		}
+		resp.Body.Close()
+		return err
+	}
+}
+
+// page — общая часть ответов Helix со списками
+type page[T any] struct {
+	Data       []T `json:"data"`
+	Pagination struct {
+		Cursor string `json:"cursor"`
+	} `json:"pagination"`
+}
+
+// getAll проходит по всем страницам ответа
+func getAll[T any](ctx context.Context, c *client, path string, params url.Values) ([]T, error) {
+	var all []T
+	for {
+		var result page[T]
+		if err := c.do(ctx, http.MethodGet, path, params, nil, &result); err != nil {
+			return nil, err
+		}
+		all = append(all, result.Data...)
+
+		if result.Pagination.Cursor == "" || len(result.Data) == 0 {
+			return all, nil
+		}
+		params.Set("after", result.Pagination.Cursor)
+	}
+}
+
+// getBatched разбивает values на запросы по MaxIDsPerRequest значений,
+// добавляя к каждому параметры base
+func getBatched[T any](ctx context.Context, c *client, path string, base url.Values, values map[string][]string) ([]T, error) {
+	var all []T
+	for key, list := range values {
+		for start := 0; start < len(list); start += MaxIDsPerRequest {
+			end := min(start+MaxIDsPerRequest, len(list))
+
+			params := url.Values{key: list[start:end]}
+			for name, value := range base {
+				params[name] = value
+			}
+			batch, err := getAll[T](ctx, c, path, params)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, batch...)
+		}
+	}
+	return all, nil
+}
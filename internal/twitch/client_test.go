@@ -0,0 +1,133 @@
+package twitch_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"twitchannouncer/internal/twitch"
+	"twitchannouncer/internal/twitch/twitchtest"
+)
+
+func TestGetStreamsPaginatesAndBatches(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.PageSize = 20
+
+	var logins []string
+	var streams []twitch.Stream
+	for i := 0; i < 150; i++ {
+		login := fmt.Sprintf("streamer_%d", i)
+		logins = append(logins, login)
+		if i%2 == 0 {
+			streams = append(streams, twitch.Stream{ID: fmt.Sprint(i), UserLogin: login})
+		}
+	}
+	streams = append(streams, twitch.Stream{ID: "rerun", UserLogin: "streamer_1", Type: "rerun"})
+	server.SetStreams(streams...)
+
+	got, err := server.Client().GetStreams(context.Background(), twitch.StreamsQuery{UserLogins: logins})
+	require.NoError(t, err)
+	assert.Len(t, got, 75)
+	// Две пачки логинов: 100 + 50, по 20 стримов на странице
+	assert.Equal(t, 5, server.Requests("/streams"))
+}
+
+func TestRefreshesTokenOn401(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.SetUsers(twitch.User{ID: "1", Login: "streamer"})
+
+	client := server.Client()
+	_, err := client.GetUsers(context.Background(), twitch.UsersQuery{Logins: []string{"streamer"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, server.TokensIssued())
+
+	server.ExpireToken()
+	users, err := client.GetUsers(context.Background(), twitch.UsersQuery{Logins: []string{"Streamer"}})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, "1", users[0].ID)
+	assert.Equal(t, 2, server.TokensIssued())
+}
+
+func TestRetriesAfterRateLimit(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.SetGames(twitch.Game{ID: "509658", Name: "Just Chatting"})
+	server.RateLimit(1)
+
+	games, err := server.Client().GetGames(context.Background(), []string{"509658"})
+	require.NoError(t, err)
+	require.Len(t, games, 1)
+	assert.Equal(t, 2, server.Requests("/games"))
+}
+
+func TestEventSubConflict(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	sub := twitch.EventSubSubscription{
+		Type:      "stream.online",
+		Version:   "1",
+		Condition: map[string]string{"broadcaster_user_id": "1"},
+		Transport: twitch.EventSubTransport{Method: "webhook", Callback: "https://example.com/twitch/eventsub", Secret: "0123456789"},
+	}
+	require.NoError(t, client.CreateEventSubSubscription(context.Background(), sub))
+
+	err := client.CreateEventSubSubscription(context.Background(), sub)
+	assert.True(t, twitch.IsStatus(err, http.StatusConflict))
+
+	subs, err := client.GetEventSubSubscriptions(context.Background())
+	require.NoError(t, err)
+	require.Len(t, subs, 1)
+	require.NoError(t, client.DeleteEventSubSubscription(context.Background(), subs[0].ID))
+	assert.Empty(t, server.Subscriptions())
+}
+
+func TestTokenStore(t *testing.T) {
+	server := twitchtest.NewServer()
+	defer server.Close()
+	server.SetUsers(twitch.User{ID: "1", Login: "streamer"})
+
+	store := twitch.NewFileTokenStore(filepath.Join(t.TempDir(), "token.json"))
+	newClient := func() twitch.Client {
+		return twitch.NewClient(twitch.Options{
+			ClientID:     server.ClientID,
+			ClientSecret: server.ClientSecret,
+			HelixURL:     server.HelixURL(),
+			AuthURL:      server.AuthURL(),
+			Store:        store,
+		})
+	}
+
+	// Параллельные запросы получают один токен
+	client := newClient()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.GetUsers(context.Background(), twitch.UsersQuery{IDs: []string{"1"}})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, server.TokensIssued())
+
+	// После перезапуска токен берётся из хранилища
+	_, err := newClient().GetUsers(context.Background(), twitch.UsersQuery{IDs: []string{"1"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, server.TokensIssued())
+
+	stored, err := store.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", stored.AccessToken)
+}
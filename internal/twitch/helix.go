@@ -0,0 +1,160 @@
+package twitch
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type Stream struct {
+	ID           string    `json:"id"`
+	UserID       string    `json:"user_id"`
+	UserLogin    string    `json:"user_login"`
+	UserName     string    `json:"user_name"`
+	GameID       string    `json:"game_id"`
+	GameName     string    `json:"game_name"`
+	Type         string    `json:"type"`
+	Title        string    `json:"title"`
+	ViewerCount  int       `json:"viewer_count"`
+	StartedAt    time.Time `json:"started_at"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	Tags         []string  `json:"tags"`
+}
+
+type StreamsQuery struct {
+	UserLogins []string
+	UserIDs    []string
+}
+
+type User struct {
+	ID              string    `json:"id"`
+	Login           string    `json:"login"`
+	DisplayName     string    `json:"display_name"`
+	Type            string    `json:"type"`
+	BroadcasterType string    `json:"broadcaster_type"`
+	Description     string    `json:"description"`
+	ProfileImageURL string    `json:"profile_image_url"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type UsersQuery struct {
+	Logins []string
+	IDs    []string
+}
+
+type Video struct {
+	ID        string    `json:"id"`
+	StreamID  string    `json:"stream_id"`
+	UserID    string    `json:"user_id"`
+	UserLogin string    `json:"user_login"`
+	Title     string    `json:"title"`
+	URL       string    `json:"url"`
+	Type      string    `json:"type"`
+	Duration  string    `json:"duration"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type VideosQuery struct {
+	UserID string
+	// Type — all, archive, highlight или upload
+	Type  string
+	First int
+}
+
+type Game struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	BoxArtURL string `json:"box_art_url"`
+}
+
+type Channel struct {
+	BroadcasterID    string   `json:"broadcaster_id"`
+	BroadcasterLogin string   `json:"broadcaster_login"`
+	BroadcasterName  string   `json:"broadcaster_name"`
+	GameID           string   `json:"game_id"`
+	GameName         string   `json:"game_name"`
+	Title            string   `json:"title"`
+	Tags             []string `json:"tags"`
+}
+
+type EventSubSubscription struct {
+	ID        string            `json:"id,omitempty"`
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Status    string            `json:"status,omitempty"`
+	Condition map[string]string `json:"condition"`
+	Transport EventSubTransport `json:"transport"`
+}
+
+type EventSubTransport struct {
+	Method   string `json:"method"`
+	Callback string `json:"callback"`
+	Secret   string `json:"secret,omitempty"`
+}
+
+// GetStreams возвращает только идущие сейчас стримы
+func (c *client) GetStreams(ctx context.Context, query StreamsQuery) ([]Stream, error) {
+	base := url.Values{"first": {strconv.Itoa(MaxIDsPerRequest)}}
+	streams, err := getBatched[Stream](ctx, c, "/streams", base, map[string][]string{
+		"user_login": query.UserLogins,
+		"user_id":    query.UserIDs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	live := streams[:0]
+	for _, stream := range streams {
+		if stream.Type == "live" {
+			live = append(live, stream)
+		}
+	}
+	return live, nil
+}
+
+func (c *client) GetUsers(ctx context.Context, query UsersQuery) ([]User, error) {
+	return getBatched[User](ctx, c, "/users", nil, map[string][]string{
+		"login": query.Logins,
+		"id":    query.IDs,
+	})
+}
+
+func (c *client) GetVideos(ctx context.Context, query VideosQuery) ([]Video, error) {
+	params := url.Values{}
+	params.Set("user_id", query.UserID)
+	if query.Type != "" {
+		params.Set("type", query.Type)
+	}
+	if query.First > 0 {
+		params.Set("first", strconv.Itoa(query.First))
+	}
+
+	// Запрос видео постранично не нужен: бот смотрит только последние записи
+	var result page[Video]
+	if err := c.do(ctx, http.MethodGet, "/videos", params, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+func (c *client) GetGames(ctx context.Context, ids []string) ([]Game, error) {
+	return getBatched[Game](ctx, c, "/games", nil, map[string][]string{"id": ids})
+}
+
+func (c *client) GetChannels(ctx context.Context, broadcasterIDs []string) ([]Channel, error) {
+	return getBatched[Channel](ctx, c, "/channels", nil, map[string][]string{"broadcaster_id": broadcasterIDs})
+}
+
+func (c *client) GetEventSubSubscriptions(ctx context.Context) ([]EventSubSubscription, error) {
+	return getAll[EventSubSubscription](ctx, c, "/eventsub/subscriptions", url.Values{})
+}
+
+func (c *client) CreateEventSubSubscription(ctx context.Context, sub EventSubSubscription) error {
+	return c.do(ctx, http.MethodPost, "/eventsub/subscriptions", nil, sub, nil)
+}
+
+func (c *client) DeleteEventSubSubscription(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/eventsub/subscriptions", url.Values{"id": {id}}, nil, nil)
+}
@@ -0,0 +1,134 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"twitchannouncer/internal/database"
+)
+
+// TokenStore хранит app access token между перезапусками. Load возвращает
+// пустой Token, если сохранённого нет.
+type TokenStore interface {
+	Load(ctx context.Context) (Token, error)
+	Save(ctx context.Context, token Token) error
+}
+
+type memoryTokenStore struct {
+	mu    sync.Mutex
+	token Token
+}
+
+// NewMemoryTokenStore не переживает перезапуск: токен будет получен заново
+func NewMemoryTokenStore() TokenStore {
+	return &memoryTokenStore{}
+}
+
+func (s *memoryTokenStore) Load(ctx context.Context) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token, nil
+}
+
+func (s *memoryTokenStore) Save(ctx context.Context, token Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+	return nil
+}
+
+type fileTokenStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileTokenStore хранит токен в отдельном JSON-файле
+func NewFileTokenStore(path string) TokenStore {
+	return &fileTokenStore{path: path}
+}
+
+type fileToken struct {
+	AccessToken string    `json:"access_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+func (s *fileTokenStore) Load(ctx context.Context) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Token{}, nil
+	}
+	if err != nil {
+		return Token{}, fmt.Errorf("ошибка чтения %s: %w", s.path, err)
+	}
+
+	var token fileToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return Token{}, fmt.Errorf("ошибка разбора %s: %w", s.path, err)
+	}
+	return Token{AccessToken: token.AccessToken, ExpiresAt: token.ExpiresAt}, nil
+}
+
+// Save пишет во временный файл и переименовывает его, чтобы при сбое не
+// остался наполовину записанный токен
+func (s *fileTokenStore) Save(ctx context.Context, token Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(fileToken{AccessToken: token.AccessToken, ExpiresAt: token.ExpiresAt})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения токена: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("ошибка сохранения токена: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("ошибка сохранения токена: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("ошибка сохранения токена: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("ошибка сохранения токена: %w", err)
+	}
+	return nil
+}
+
+// postgresTokenStore позволяет нескольким экземплярам бота пользоваться одним токеном
+type postgresTokenStore struct {
+	db       *database.DB
+	clientID string
+}
+
+func NewPostgresTokenStore(db *database.DB, clientID string) TokenStore {
+	return &postgresTokenStore{db: db, clientID: clientID}
+}
+
+func (s *postgresTokenStore) Load(ctx context.Context) (Token, error) {
+	accessToken, expiresAt, err := s.db.GetTwitchToken(ctx, s.clientID)
+	if err != nil {
+		return Token{}, err
+	}
+	return Token{AccessToken: accessToken, ExpiresAt: expiresAt}, nil
+}
+
+func (s *postgresTokenStore) Save(ctx context.Context, token Token) error {
+	return s.db.SaveTwitchToken(ctx, s.clientID, token.AccessToken, token.ExpiresAt)
+}
@@ -0,0 +1,326 @@
+// Package twitchtest — поддельный Twitch Helix API для тестов без сети
+package twitchtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"twitchannouncer/internal/twitch"
+)
+
+// Server хранит стримы, пользователей и остальное в памяти и отвечает
+// в формате Helix. Списки отдаются страницами по PageSize элементов.
+type Server struct {
+	*httptest.Server
+
+	ClientID     string
+	ClientSecret string
+	PageSize     int
+
+	mu            sync.Mutex
+	token         string
+	tokenCount    int
+	streams       []twitch.Stream
+	users         []twitch.User
+	videos        []twitch.Video
+	games         []twitch.Game
+	channels      []twitch.Channel
+	subscriptions []twitch.EventSubSubscription
+	nextID        int
+	// requests считает запросы к Helix по пути, например "/streams"
+	requests map[string]int
+	// rateLimited — сколько следующих запросов получат 429
+	rateLimited int
+}
+
+func NewServer() *Server {
+	s := &Server{
+		ClientID:     "test-client-id",
+		ClientSecret: "test-client-secret",
+		PageSize:     100,
+		requests:     make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", s.handleToken)
+	mux.HandleFunc("/helix/", s.handleHelix)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *Server) HelixURL() string {
+	return s.URL + "/helix"
+}
+
+func (s *Server) AuthURL() string {
+	return s.URL + "/oauth2/token"
+}
+
+// Client возвращает клиента, настроенного на этот сервер
+func (s *Server) Client() twitch.Client {
+	return twitch.NewClient(twitch.Options{
+		ClientID:     s.ClientID,
+		ClientSecret: s.ClientSecret,
+		HelixURL:     s.HelixURL(),
+		AuthURL:      s.AuthURL(),
+		HTTPClient:   s.Server.Client(),
+	})
+}
+
+func (s *Server) SetStreams(streams ...twitch.Stream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range streams {
+		if streams[i].Type == "" {
+			streams[i].Type = "live"
+		}
+	}
+	s.streams = streams
+}
+
+func (s *Server) SetUsers(users ...twitch.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = users
+}
+
+func (s *Server) SetVideos(videos ...twitch.Video) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.videos = videos
+}
+
+func (s *Server) SetGames(games ...twitch.Game) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.games = games
+}
+
+func (s *Server) SetChannels(channels ...twitch.Channel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channels = channels
+}
+
+func (s *Server) Subscriptions() []twitch.EventSubSubscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]twitch.EventSubSubscription(nil), s.subscriptions...)
+}
+
+// ExpireToken делает выданный токен недействительным: следующий запрос получит 401
+func (s *Server) ExpireToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = ""
+}
+
+// TokensIssued — сколько раз клиенты получали токен
+func (s *Server) TokensIssued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokenCount
+}
+
+// RateLimit отвечает 429 на следующие n запросов
+func (s *Server) RateLimit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimited = n
+}
+
+// Requests возвращает количество запросов к пути Helix, например "/streams"
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil ||
+		r.PostForm.Get("client_id") != s.ClientID ||
+		r.PostForm.Get("client_secret") != s.ClientSecret ||
+		r.PostForm.Get("grant_type") != "client_credentials" {
+		writeError(w, http.StatusBadRequest, "invalid client")
+		return
+	}
+
+	s.mu.Lock()
+	s.tokenCount++
+	s.token = fmt.Sprintf("token-%d", s.tokenCount)
+	token := s.token
+	s.mu.Unlock()
+
+	writeJSON(w, map[string]any{
+		"access_token": token,
+		"expires_in":   3600,
+		"token_type":   "bearer",
+	})
+}
+
+func (s *Server) handleHelix(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/helix")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[path]++
+
+	if r.Header.Get("Client-ID") != s.ClientID {
+		writeError(w, http.StatusUnauthorized, "invalid client id")
+		return
+	}
+	if s.token == "" || r.Header.Get("Authorization") != "Bearer "+s.token {
+		writeError(w, http.StatusUnauthorized, "invalid oauth token")
+		return
+	}
+
+	reset := strconv.FormatInt(time.Now().Add(time.Second).Unix(), 10)
+	w.Header().Set("Ratelimit-Limit", "800")
+	w.Header().Set("Ratelimit-Reset", reset)
+	if s.rateLimited > 0 {
+		s.rateLimited--
+		w.Header().Set("Ratelimit-Remaining", "0")
+		writeError(w, http.StatusTooManyRequests, "too many requests")
+		return
+	}
+	w.Header().Set("Ratelimit-Remaining", "799")
+
+	query := r.URL.Query()
+	switch {
+	case path == "/streams" && r.Method == http.MethodGet:
+		logins := set(query["user_login"])
+		ids := set(query["user_id"])
+		writePage(w, r, s.PageSize, filter(s.streams, func(st twitch.Stream) bool {
+			return logins[strings.ToLower(st.UserLogin)] || ids[st.UserID]
+		}))
+	case path == "/users" && r.Method == http.MethodGet:
+		logins := set(query["login"])
+		ids := set(query["id"])
+		writePage(w, r, s.PageSize, filter(s.users, func(u twitch.User) bool {
+			return logins[strings.ToLower(u.Login)] || ids[u.ID]
+		}))
+	case path == "/videos" && r.Method == http.MethodGet:
+		videoType := query.Get("type")
+		writePage(w, r, s.PageSize, filter(s.videos, func(v twitch.Video) bool {
+			return v.UserID == query.Get("user_id") && (videoType == "" || videoType == "all" || v.Type == videoType)
+		}))
+	case path == "/games" && r.Method == http.MethodGet:
+		ids := set(query["id"])
+		writePage(w, r, s.PageSize, filter(s.games, func(g twitch.Game) bool {
+			return ids[g.ID]
+		}))
+	case path == "/channels" && r.Method == http.MethodGet:
+		ids := set(query["broadcaster_id"])
+		writePage(w, r, s.PageSize, filter(s.channels, func(c twitch.Channel) bool {
+			return ids[c.BroadcasterID]
+		}))
+	case path == "/eventsub/subscriptions":
+		s.handleSubscriptions(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// handleSubscriptions вызывается под s.mu
+func (s *Server) handleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writePage(w, r, s.PageSize, s.subscriptions)
+
+	case http.MethodPost:
+		var sub twitch.EventSubSubscription
+		if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		for _, existing := range s.subscriptions {
+			if existing.Type == sub.Type && existing.Transport.Callback == sub.Transport.Callback &&
+				existing.Condition["broadcaster_user_id"] == sub.Condition["broadcaster_user_id"] {
+				writeError(w, http.StatusConflict, "subscription already exists")
+				return
+			}
+		}
+		s.nextID++
+		sub.ID = fmt.Sprintf("sub-%d", s.nextID)
+		sub.Status = "enabled"
+		sub.Transport.Secret = ""
+		s.subscriptions = append(s.subscriptions, sub)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		writeJSON(w, map[string]any{"data": []twitch.EventSubSubscription{sub}})
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		for i, sub := range s.subscriptions {
+			if sub.ID == id {
+				s.subscriptions = append(s.subscriptions[:i], s.subscriptions[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "subscription not found")
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// writePage отдаёт страницу items, начиная с курсора after
+func writePage[T any](w http.ResponseWriter, r *http.Request, size int, items []T) {
+	start, _ := strconv.Atoi(r.URL.Query().Get("after"))
+	if start > len(items) {
+		start = len(items)
+	}
+	end := min(start+size, len(items))
+
+	result := map[string]any{"data": items[start:end]}
+	if end < len(items) {
+		result["pagination"] = map[string]string{"cursor": strconv.Itoa(end)}
+	} else {
+		result["pagination"] = map[string]string{}
+	}
+	writeJSON(w, result)
+}
+
+func filter[T any](items []T, keep func(T) bool) []T {
+	result := []T{}
+	for _, item := range items {
+		if keep(item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func set(values []string) map[string]bool {
+	result := make(map[string]bool, len(values))
+	for _, value := range values {
+		result[strings.ToLower(value)] = true
+		result[value] = true
+	}
+	return result
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":   http.StatusText(status),
+		"status":  status,
+		"message": message,
+	})
+}
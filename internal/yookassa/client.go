@@ -2,35 +2,141 @@ package yookassa
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
-	"os"
+	"strings"
 	"time"
+
+	"twitchannouncer/internal/config"
+)
+
+const (
+	DefaultAPIURL = "https://api.yookassa.ru/v3"
+
+	// Сколько раз отправлять запрос при сетевых ошибках и ответах 5xx
+	maxAttempts = 4
+	// Пауза перед первым повтором, дальше она удваивается
+	defaultRetryDelay = 500 * time.Millisecond
 )
 
 type Client struct {
 	ShopID    string
 	SecretKey string
 	HTTP      *http.Client
-}
+	APIURL    string
+	// RetryDelay — пауза перед первым повтором запроса
+	RetryDelay time.Duration
 
-func NewClient() *Client {
-	shopID := os.Getenv("YOOKASSA_SHOP_ID")
-	secretKey := os.Getenv("YOOKASSA_SECRET_KEY")
+	Plans     []Plan
+	Currency  string
+	ReturnURL string
+}
 
-	fmt.Println("Shop ID:", shopID)
-	fmt.Println("Secret Key:", secretKey)
+// Options — параметры клиента YooKassa и тарифа Pro
+type Options struct {
+	ShopID    string
+	SecretKey string
+	// APIURL — адрес API; пустое значение — DefaultAPIURL
+	APIURL    string
+	Plans     []Plan
+	Currency  string
+	ReturnURL string
+}
 
+func NewClient(opts Options) *Client {
+	if opts.APIURL == "" {
+		opts.APIURL = DefaultAPIURL
+	}
 	return &Client{
-		ShopID:    os.Getenv("YOOKASSA_SHOP_ID"),
-		SecretKey: os.Getenv("YOOKASSA_SECRET_KEY"),
-		HTTP:      &http.Client{Timeout: 10 * time.Second},
+		ShopID:     opts.ShopID,
+		SecretKey:  opts.SecretKey,
+		HTTP:       &http.Client{Timeout: 10 * time.Second},
+		APIURL:     strings.TrimSuffix(opts.APIURL, "/"),
+		RetryDelay: defaultRetryDelay,
+		Plans:      opts.Plans,
+		Currency:   opts.Currency,
+		ReturnURL:  opts.ReturnURL,
+	}
+}
+
+// Plan — тариф Pro: срок в месяцах и цена в формате YooKassa (50.00)
+type Plan struct {
+	ID     string
+	Months int
+	Price  string
+}
+
+// DefaultPlanID — тариф платежей, созданных до появления тарифов
+const DefaultPlanID = "1m"
+
+// Plans собирает тарифы из цен в конфигурации
+func Plans(cfg config.Config) []Plan {
+	return []Plan{
+		{ID: DefaultPlanID, Months: 1, Price: cfg.ProPrice},
+		{ID: "3m", Months: 3, Price: cfg.ProPrice3Months},
+		{ID: "12m", Months: 12, Price: cfg.ProPrice12Months},
+	}
+}
+
+func (c *Client) Plan(id string) (Plan, bool) {
+	for _, plan := range c.Plans {
+		if plan.ID == id {
+			return plan, true
+		}
+	}
+	return Plan{}, false
+}
+
+// PriceText — цена для показа пользователю, например 50₽
+func (c *Client) PriceText(price string) string {
+	price = strings.TrimSuffix(price, ".00")
+	if c.Currency == "RUB" {
+		return price + "₽"
+	}
+	return price + " " + c.Currency
+}
+
+// APIError — ошибка из тела ответа YooKassa
+type APIError struct {
+	StatusCode  int
+	ID          string `json:"id"`
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Parameter   string `json:"parameter"`
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("ошибка от YooKassa [%d %s]: %s", e.StatusCode, e.Code, e.Description)
+	if e.Parameter != "" {
+		msg += fmt.Sprintf(" (параметр %s)", e.Parameter)
+	}
+	return msg
+}
+
+// IsCode сообщает, что err — ответ YooKassa с кодом ошибки code,
+// например invalid_request или not_found
+func IsCode(err error, code string) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+func parseAPIError(statusCode int, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: statusCode}
+	if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Code == "" {
+		apiErr.Description = strings.TrimSpace(string(body))
 	}
+	return apiErr
 }
 
-func (c *Client) NewRequest(method, url string, body []byte) (*http.Request, error) {
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
+func (c *Client) NewRequest(ctx context.Context, method, url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
@@ -40,10 +146,73 @@ func (c *Client) NewRequest(method, url string, body []byte) (*http.Request, err
 
 	req.Header.Set("Authorization", "Basic "+authEncoded)
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Idempotence-Key", generateIdempotenceKey())
 	return req, nil
 }
 
-func generateIdempotenceKey() string {
-	return time.Now().Format("20060102150405")
+// do отправляет запрос и возвращает тело успешного ответа. При сетевой ошибке
+// или ответе 5xx запрос повторяется с экспоненциальной паузой. POST-запросы
+// повторяются только с ключом идемпотентности, иначе повтор может создать
+// второй платёж. Пауза между повторами прерывается отменой ctx.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, idempotenceKey string) ([]byte, error) {
+	retry := method == "GET" || idempotenceKey != ""
+	delay := c.RetryDelay
+
+	for attempt := 1; ; attempt++ {
+		req, err := c.NewRequest(ctx, method, c.APIURL+path, body)
+		if err != nil {
+			return nil, err
+		}
+		if idempotenceKey != "" {
+			req.Header.Set("Idempotence-Key", idempotenceKey)
+		}
+
+		respBody, statusCode, err := c.send(req)
+		if err == nil && statusCode < 500 {
+			if statusCode >= 400 {
+				return nil, parseAPIError(statusCode, respBody)
+			}
+			return respBody, nil
+		}
+		if err == nil {
+			err = parseAPIError(statusCode, respBody)
+		}
+
+		if !retry || attempt >= maxAttempts {
+			return nil, err
+		}
+		log.Printf("Запрос %s %s к YooKassa не удался (попытка %d из %d): %v", method, path, attempt, maxAttempts, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("запрос %s %s к YooKassa прерван: %w", method, path, ctx.Err())
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+func (c *Client) send(req *http.Request) ([]byte, int, error) {
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return body, resp.StatusCode, nil
+}
+
+// newIdempotenceKey возвращает случайный UUID версии 4
+func newIdempotenceKey() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("crypto/rand: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
 }
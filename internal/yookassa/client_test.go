@@ -0,0 +1,120 @@
+package yookassa
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewIdempotenceKey(t *testing.T) {
+	uuidV4 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		key := newIdempotenceKey()
+		require.Regexp(t, uuidV4, key)
+		require.False(t, seen[key], "повторный ключ %s", key)
+		seen[key] = true
+	}
+}
+
+func TestCreatePaymentRetriesWithSameKey(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get("Idempotence-Key"))
+		attempt := len(keys)
+		mu.Unlock()
+
+		if attempt < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"id":"2c5d","status":"pending","confirmation":{"type":"redirect","confirmation_url":"https://pay.example/2c5d"}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(Options{APIURL: server.URL, Currency: "RUB"})
+	client.RetryDelay = time.Millisecond
+
+	payment, err := client.CreatePayment(context.Background(), 42, "user@example.com", Plan{ID: "1m", Months: 1, Price: "50.00"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://pay.example/2c5d", payment.Confirmation.URL)
+
+	require.Len(t, keys, 3)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1])
+	assert.Equal(t, keys[0], keys[2])
+	assert.Equal(t, keys[0], payment.IdempotenceKey)
+}
+
+func TestCreatePaymentAPIError(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"type":"error","id":"ab12","code":"invalid_request","description":"Invalid email","parameter":"receipt.customer.email"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(Options{APIURL: server.URL, Currency: "RUB"})
+	client.RetryDelay = time.Millisecond
+
+	_, err := client.CreatePayment(context.Background(), 42, "bad", Plan{ID: "1m", Months: 1, Price: "50.00"})
+	require.Error(t, err)
+	// Ошибки 4xx не повторяются
+	assert.Equal(t, 1, requests)
+	assert.True(t, IsCode(err, "invalid_request"))
+
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Equal(t, "receipt.customer.email", apiErr.Parameter)
+}
+
+func TestGetPaymentGivesUpAfterMaxAttempts(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("upstream unavailable"))
+	}))
+	defer server.Close()
+
+	client := NewClient(Options{APIURL: server.URL})
+	client.RetryDelay = time.Millisecond
+
+	_, err := client.GetPayment(context.Background(), "2c5d")
+	require.Error(t, err)
+	assert.Equal(t, maxAttempts, requests)
+	assert.Contains(t, err.Error(), "upstream unavailable")
+}
+
+func TestGetPaymentStopsRetryingOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		cancel()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewClient(Options{APIURL: server.URL})
+	client.RetryDelay = time.Hour
+
+	start := time.Now()
+	_, err := client.GetPayment(ctx, "2c5d")
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, requests)
+	assert.Less(t, time.Since(start), time.Second, "пауза перед повтором не прервана")
+}
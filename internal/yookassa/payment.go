@@ -1,10 +1,11 @@
 package yookassa
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
+	"net/url"
 )
 
 type YooKassaPaymentRequest struct {
@@ -35,24 +36,62 @@ type YooKassaPaymentRequest struct {
 	} `json:"receipt"`
 }
 
-type YooKassaPaymentResponse struct {
-	ID           string `json:"id"`
-	Status       string `json:"status"`
+// Amount — сумма платежа в формате YooKassa
+type Amount struct {
+	Value    string `json:"value"`
+	Currency string `json:"currency"`
+}
+
+// Payment — платёж, как его возвращает API YooKassa
+type Payment struct {
+	ID           string            `json:"id"`
+	Status       string            `json:"status"`
+	Paid         bool              `json:"paid"`
+	Amount       Amount            `json:"amount"`
+	Metadata     map[string]string `json:"metadata"`
 	Confirmation struct {
 		Type string `json:"type"`
 		URL  string `json:"confirmation_url"`
 	} `json:"confirmation"`
+	// Raw — ответ API целиком, сохраняется в истории платежей
+	Raw json.RawMessage `json:"-"`
+	// IdempotenceKey — ключ, с которым платёж был создан
+	IdempotenceKey string `json:"-"`
+}
+
+// GetPayment запрашивает платёж у YooKassa. Данным из уведомления верить
+// нельзя, поэтому вебхук сверяется с ответом API.
+func (c *Client) GetPayment(ctx context.Context, id string) (Payment, error) {
+	body, err := c.do(ctx, "GET", "/payments/"+url.PathEscape(id), nil, "")
+	if err != nil {
+		return Payment{}, err
+	}
+	return decodePayment(body)
 }
 
-func (c *Client) CreatePayment(telegramID int64, email string) (string, error) {
+func decodePayment(body []byte) (Payment, error) {
+	var payment Payment
+	if err := json.Unmarshal(body, &payment); err != nil {
+		return Payment{}, fmt.Errorf("ошибка при декодировании ответа YooKassa: %w", err)
+	}
+	payment.Raw = body
+	return payment, nil
+}
+
+// CreatePayment создаёт платёж за тариф Pro; ссылка на оплату — в
+// Confirmation.URL. Тариф передаётся в metadata, по нему вебхук узнаёт срок.
+func (c *Client) CreatePayment(ctx context.Context, telegramID int64, email string, plan Plan) (Payment, error) {
 	reqBody := YooKassaPaymentRequest{}
-	reqBody.Amount.Value = "50.00"
-	reqBody.Amount.Currency = "RUB"
+	reqBody.Amount.Value = plan.Price
+	reqBody.Amount.Currency = c.Currency
 	reqBody.Confirmation.Type = "redirect"
 	reqBody.Capture = true
-	reqBody.Confirmation.ReturnURL = "https://t.me/Twitchmanannouncer_bot"
-	reqBody.Description = fmt.Sprintf("Pro подписка TwitchAnnouncer для пользователя %d", telegramID)
-	reqBody.Metadata = map[string]string{"telegram_id": fmt.Sprintf("%d", telegramID)}
+	reqBody.Confirmation.ReturnURL = c.ReturnURL
+	reqBody.Description = fmt.Sprintf("Pro подписка TwitchAnnouncer на %d мес. для пользователя %d", plan.Months, telegramID)
+	reqBody.Metadata = map[string]string{
+		"telegram_id": fmt.Sprintf("%d", telegramID),
+		"plan":        plan.ID,
+	}
 
 	// Добавляем чек
 	reqBody.Receipt.Customer.Email = email
@@ -66,47 +105,38 @@ func (c *Client) CreatePayment(telegramID int64, email string) (string, error) {
 		VatCode int `json:"vat_code"`
 	}{
 		{
-			Description: "Pro подписка TwitchAnnouncer",
+			Description: fmt.Sprintf("Pro подписка TwitchAnnouncer на %d мес.", plan.Months),
 			Quantity:    "1",
 			Amount: struct {
 				Value    string `json:"value"`
 				Currency string `json:"currency"`
 			}{
-				Value:    "50.00",
-				Currency: "RUB",
+				Value:    plan.Price,
+				Currency: c.Currency,
 			},
 			VatCode: 1, // Без НДС
 		},
 	}
 
+	// Повторы запроса идут с тем же ключом, и YooKassa не создаст второй платёж
+	key := newIdempotenceKey()
 	jsonData, _ := json.Marshal(reqBody)
-	req, err := c.NewRequest("POST", "https://api.yookassa.ru/v3/payments", jsonData)
+	body, err := c.do(ctx, "POST", "/payments", jsonData, key)
 	if err != nil {
-		return "", err
-	}
-
-	resp, err := c.HTTP.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 400 {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("ошибка от YooKassa [%d]: %s", resp.StatusCode, string(bodyBytes))
+		return Payment{}, err
 	}
 
-	var respData YooKassaPaymentResponse
-	err = json.NewDecoder(resp.Body).Decode(&respData)
+	payment, err := decodePayment(body)
 	if err != nil {
-		return "", err
+		return Payment{}, err
 	}
+	payment.IdempotenceKey = key
 
-	if respData.Confirmation.URL == "" {
-		return "", fmt.Errorf("не удалось получить ссылку на оплату")
+	if payment.Confirmation.URL == "" {
+		return Payment{}, fmt.Errorf("не удалось получить ссылку на оплату")
 	}
 
-	log.Printf("Создана платежная ссылка: %s", respData.Confirmation.URL)
+	log.Printf("Создан платёж %s, ссылка на оплату: %s", payment.ID, payment.Confirmation.URL)
 
-	return respData.Confirmation.URL, nil
+	return payment, nil
 }
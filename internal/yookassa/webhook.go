@@ -2,12 +2,17 @@ package yookassa
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
+	"math/big"
+	"net"
 	"net/http"
+	"net/netip"
 	"strconv"
+	"strings"
 
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"twitchannouncer/internal/config"
 	"twitchannouncer/internal/database"
 )
 
@@ -23,8 +28,37 @@ type WebhookNotification struct {
 	} `json:"object"`
 }
 
-func HandleWebhook(db *database.DB, bot *tgbotapi.BotAPI) http.HandlerFunc {
+// webhookNetworks — адреса, с которых YooKassa отправляет уведомления:
+// https://yookassa.ru/developers/using-api/webhooks#ip
+var webhookNetworks = []netip.Prefix{
+	netip.MustParsePrefix("185.71.76.0/27"),
+	netip.MustParsePrefix("185.71.77.0/27"),
+	netip.MustParsePrefix("77.75.153.0/25"),
+	netip.MustParsePrefix("77.75.156.11/32"),
+	netip.MustParsePrefix("77.75.156.35/32"),
+	netip.MustParsePrefix("77.75.154.128/25"),
+	netip.MustParsePrefix("2a02:5180::/32"),
+}
+
+// Notifier отправляет пользователю личное сообщение
+type Notifier interface {
+	Notify(userID int64, text string) error
+}
+
+// HandleWebhook продлевает Pro на срок оплаченного тарифа.
+// Уведомлению не доверяем: проверяем адрес отправителя (если включено
+// yookassa_verify_ip) и перезапрашиваем платёж у YooKassa.
+func HandleWebhook(cfg config.Config, db *database.DB, notifier Notifier, payments *Client) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.YooKassaVerifyIP {
+			ip := clientIP(r, cfg.TrustForwardedFor)
+			if !isWebhookIP(ip) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				log.Printf("Отклонён webhook YooKassa с недоверенного адреса %s", ip)
+				return
+			}
+		}
+
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			http.Error(w, "can't read body", http.StatusBadRequest)
@@ -41,36 +75,177 @@ func HandleWebhook(db *database.DB, bot *tgbotapi.BotAPI) http.HandlerFunc {
 			return
 		}
 
-		tgIDStr := notif.Object.Metadata.TelegramID
-		if tgIDStr == "" {
-			log.Println("Отсутствует telegram_id в metadata")
+		// Статус, который должен вернуть API, если уведомление настоящее
+		var wantStatus string
+		switch notif.Event {
+		case "payment.succeeded":
+			wantStatus = database.PaymentSucceeded
+		case "payment.canceled":
+			wantStatus = database.PaymentCanceled
+		default:
+			log.Printf("Необработанное событие: %s", notif.Event)
 			w.WriteHeader(http.StatusOK)
 			return
 		}
 
-		tgID, err := strconv.ParseInt(tgIDStr, 10, 64)
+		if notif.Object.ID == "" {
+			http.Error(w, "missing payment id", http.StatusBadRequest)
+			log.Println("Отсутствует ID платежа в уведомлении YooKassa")
+			return
+		}
+
+		payment, err := payments.GetPayment(r.Context(), notif.Object.ID)
 		if err != nil {
-			log.Printf("Неверный telegram_id: %v", err)
-			w.WriteHeader(http.StatusOK)
+			// Без ответа 200 YooKassa повторит уведомление позже
+			http.Error(w, "can't verify payment", http.StatusInternalServerError)
+			log.Printf("Не удалось проверить платёж %s: %v", notif.Object.ID, err)
+			return
+		}
+		if payment.Status != wantStatus {
+			http.Error(w, "payment not confirmed", http.StatusForbidden)
+			log.Printf("Отклонено уведомление %s о платеже %s с адреса %s: платёж в статусе %s",
+				notif.Event, notif.Object.ID, clientIP(r, cfg.TrustForwardedFor), payment.Status)
 			return
 		}
 
-		switch notif.Event {
-		case "payment.succeeded":
-			err := db.MakeUserPro(tgID)
-			if err != nil {
-				log.Printf("Ошибка при установке Pro-подписки для %d: %v", tgID, err)
-			} else {
-				msg := tgbotapi.NewMessage(tgID, "✅ Ваша подписка Pro активирована! Спасибо за поддержку!")
-				if _, err := bot.Send(msg); err != nil {
-					log.Printf("Не удалось отправить сообщение пользователю %d: %v", tgID, err)
-				}
-				log.Printf("Pro активирована для пользователя %d", tgID)
+		stored, err := db.GetPayment(r.Context(), payment.ID)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			log.Print(err)
+			return
+		}
+
+		tgID, plan, err := payments.verifyPayment(payment, stored)
+		if err != nil {
+			http.Error(w, "payment not confirmed", http.StatusForbidden)
+			log.Printf("Отклонено уведомление YooKassa о платеже %s с адреса %s: %v", notif.Object.ID, clientIP(r, cfg.TrustForwardedFor), err)
+			return
+		}
+
+		record := database.Payment{
+			ID:       payment.ID,
+			UserID:   tgID,
+			Amount:   payment.Amount.Value,
+			Currency: payment.Amount.Currency,
+			Months:   plan.Months,
+			Payload:  payment.Raw,
+		}
+
+		if payment.Status == database.PaymentCanceled {
+			if _, err := db.CancelPayment(r.Context(), record); err != nil {
+				http.Error(w, "db error", http.StatusInternalServerError)
+				log.Print(err)
+				return
 			}
-		default:
-			log.Printf("Необработанное событие: %s", notif.Event)
+			log.Printf("Платёж %s пользователя %d отменён", payment.ID, tgID)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		applied, err := db.CompletePayment(r.Context(), record)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			log.Printf("Ошибка при установке Pro-подписки для %d: %v", tgID, err)
+			return
 		}
+		if !applied {
+			log.Printf("Платёж %s уже обработан, повторное уведомление пропущено", payment.ID)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if err := notifier.Notify(tgID, "✅ Ваша подписка Pro активирована! Спасибо за поддержку!"); err != nil {
+			log.Printf("Не удалось отправить сообщение пользователю %d: %v", tgID, err)
+		}
+		log.Printf("Pro активирована для пользователя %d", tgID)
 
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+// verifyPayment сверяет платёж, полученный из API, с записью, которую
+// сохранил CreatePayment, и возвращает Telegram ID покупателя и тариф.
+// Цены тарифов в конфиге могут измениться, пока пользователь платит, поэтому
+// с текущими ценами сверяются только платежи без записи в базе. Отменённый
+// платёж проверяется только по metadata.
+func (c *Client) verifyPayment(payment Payment, stored *database.Payment) (int64, Plan, error) {
+	planID := payment.Metadata["plan"]
+	if planID == "" {
+		planID = DefaultPlanID
+	}
+
+	var plan Plan
+	currency := c.Currency
+	if stored != nil {
+		plan = Plan{ID: planID, Months: stored.Months, Price: stored.Amount}
+		currency = stored.Currency
+	} else {
+		var ok bool
+		if plan, ok = c.Plan(planID); !ok {
+			return 0, Plan{}, fmt.Errorf("неизвестный тариф %q", planID)
+		}
+	}
+
+	if payment.Status == database.PaymentSucceeded {
+		if !payment.Paid {
+			return 0, Plan{}, fmt.Errorf("платёж в статусе %s не оплачен", payment.Status)
+		}
+		if !sameAmount(payment.Amount.Value, plan.Price) || payment.Amount.Currency != currency {
+			return 0, Plan{}, fmt.Errorf("сумма %s %s, ожидалось %s %s за тариф %s",
+				payment.Amount.Value, payment.Amount.Currency, plan.Price, currency, plan.ID)
+		}
+	} else if payment.Status != database.PaymentCanceled {
+		return 0, Plan{}, fmt.Errorf("платёж в статусе %s", payment.Status)
+	}
+
+	tgIDStr := payment.Metadata["telegram_id"]
+	if tgIDStr == "" {
+		return 0, Plan{}, fmt.Errorf("отсутствует telegram_id в metadata")
+	}
+	tgID, err := strconv.ParseInt(tgIDStr, 10, 64)
+	if err != nil {
+		return 0, Plan{}, fmt.Errorf("неверный telegram_id %q", tgIDStr)
+	}
+	if stored != nil && stored.UserID != tgID {
+		return 0, Plan{}, fmt.Errorf("платёж создан для пользователя %d, в metadata %d", stored.UserID, tgID)
+	}
+	return tgID, plan, nil
+}
+
+// sameAmount сравнивает суммы как числа: в базе сумма хранится как NUMERIC,
+// и "50" из конфига должно совпадать с "50.00" из YooKassa
+func sameAmount(a, b string) bool {
+	x, okX := new(big.Rat).SetString(a)
+	y, okY := new(big.Rat).SetString(b)
+	return okX && okY && x.Cmp(y) == 0
+}
+
+// clientIP возвращает адрес отправителя. За reverse proxy это последний адрес
+// в X-Forwarded-For — его добавил наш прокси, остальные мог подставить клиент.
+func clientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func isWebhookIP(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, network := range webhookNetworks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package yookassa
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"twitchannouncer/internal/database"
+)
+
+func TestIsWebhookIP(t *testing.T) {
+	assert.True(t, isWebhookIP("185.71.76.5"))
+	assert.True(t, isWebhookIP("77.75.156.11"))
+	assert.True(t, isWebhookIP("::ffff:185.71.77.1"))
+	assert.True(t, isWebhookIP("2a02:5180::1"))
+	assert.False(t, isWebhookIP("77.75.156.12"))
+	assert.False(t, isWebhookIP("127.0.0.1"))
+	assert.False(t, isWebhookIP("not an ip"))
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("POST", "/yookassa/webhook", nil)
+	r.RemoteAddr = "10.0.0.2:51234"
+	r.Header.Set("X-Forwarded-For", "185.71.76.1, 203.0.113.7")
+
+	assert.Equal(t, "10.0.0.2", clientIP(r, false))
+	// Первый адрес подставлен клиентом, доверяем только добавленному прокси
+	assert.Equal(t, "203.0.113.7", clientIP(r, true))
+}
+
+func TestVerifyPayment(t *testing.T) {
+	client := NewClient(Options{
+		Currency: "RUB",
+		Plans: []Plan{
+			{ID: "1m", Months: 1, Price: "50.00"},
+			{ID: "3m", Months: 3, Price: "135.00"},
+		},
+	})
+	payment := Payment{
+		ID:       "2c5d",
+		Status:   "succeeded",
+		Paid:     true,
+		Amount:   Amount{Value: "135.00", Currency: "RUB"},
+		Metadata: map[string]string{"telegram_id": "42", "plan": "3m"},
+	}
+
+	tgID, plan, err := client.verifyPayment(payment, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), tgID)
+	assert.Equal(t, 3, plan.Months)
+
+	pending := payment
+	pending.Status = "pending"
+	pending.Paid = false
+	_, _, err = client.verifyPayment(pending, nil)
+	assert.Error(t, err)
+
+	// Цена месячного тарифа не подходит к тарифу на 3 месяца
+	cheap := payment
+	cheap.Amount.Value = "50.00"
+	_, _, err = client.verifyPayment(cheap, nil)
+	assert.Error(t, err)
+
+	dollars := payment
+	dollars.Amount.Currency = "USD"
+	_, _, err = client.verifyPayment(dollars, nil)
+	assert.Error(t, err)
+
+	unknownPlan := payment
+	unknownPlan.Metadata = map[string]string{"telegram_id": "42", "plan": "forever"}
+	_, _, err = client.verifyPayment(unknownPlan, nil)
+	assert.Error(t, err)
+
+	// Платежи без тарифа в metadata созданы до появления тарифов — это месяц
+	legacy := payment
+	legacy.Amount.Value = "50.00"
+	legacy.Metadata = map[string]string{"telegram_id": "42"}
+	_, plan, err = client.verifyPayment(legacy, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, plan.Months)
+
+	canceled := payment
+	canceled.Status = "canceled"
+	canceled.Paid = false
+	tgID, _, err = client.verifyPayment(canceled, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), tgID)
+
+	noTelegramID := payment
+	noTelegramID.Metadata = map[string]string{"plan": "3m"}
+	_, _, err = client.verifyPayment(noTelegramID, nil)
+	assert.Error(t, err)
+}
+
+func TestVerifyPaymentAgainstStoredPayment(t *testing.T) {
+	// Цену тарифа на 3 месяца подняли после создания платежа
+	client := NewClient(Options{
+		Currency: "RUB",
+		Plans:    []Plan{{ID: "3m", Months: 3, Price: "150.00"}},
+	})
+	payment := Payment{
+		ID:       "2c5d",
+		Status:   "succeeded",
+		Paid:     true,
+		Amount:   Amount{Value: "135.00", Currency: "RUB"},
+		Metadata: map[string]string{"telegram_id": "42", "plan": "3m"},
+	}
+	stored := &database.Payment{ID: "2c5d", UserID: 42, Amount: "135.00", Currency: "RUB", Months: 3, Status: "pending"}
+
+	_, _, err := client.verifyPayment(payment, nil)
+	assert.Error(t, err, "без записи сверяемся с текущей ценой")
+
+	tgID, plan, err := client.verifyPayment(payment, stored)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), tgID)
+	assert.Equal(t, 3, plan.Months)
+
+	// Тариф удалён из конфига, но платёж по нему уже создан
+	removed := payment
+	removed.Metadata = map[string]string{"telegram_id": "42", "plan": "old"}
+	_, plan, err = client.verifyPayment(removed, stored)
+	require.NoError(t, err)
+	assert.Equal(t, 3, plan.Months)
+
+	cheap := payment
+	cheap.Amount.Value = "50.00"
+	_, _, err = client.verifyPayment(cheap, stored)
+	assert.Error(t, err)
+
+	dollars := payment
+	dollars.Amount.Currency = "USD"
+	_, _, err = client.verifyPayment(dollars, stored)
+	assert.Error(t, err)
+
+	otherUser := payment
+	otherUser.Metadata = map[string]string{"telegram_id": "43", "plan": "3m"}
+	_, _, err = client.verifyPayment(otherUser, stored)
+	assert.Error(t, err)
+}
+
+func TestSameAmount(t *testing.T) {
+	assert.True(t, sameAmount("50.00", "50.00"))
+	assert.True(t, sameAmount("50", "50.00"))
+	assert.False(t, sameAmount("50.01", "50.00"))
+	assert.False(t, sameAmount("", "50.00"))
+	assert.False(t, sameAmount("abc", "abc"))
+}
+
+func TestGetPayment(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "shop" || pass != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/payments/2c5d" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"id":"2c5d","status":"succeeded","paid":true,"amount":{"value":"50.00","currency":"RUB"},"metadata":{"telegram_id":"42"}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(Options{ShopID: "shop", SecretKey: "key", APIURL: server.URL})
+	payment, err := client.GetPayment(context.Background(), "2c5d")
+	require.NoError(t, err)
+	assert.Equal(t, "succeeded", payment.Status)
+	assert.Equal(t, "42", payment.Metadata["telegram_id"])
+
+	_, err = client.GetPayment(context.Background(), "missing")
+	assert.Error(t, err)
+}